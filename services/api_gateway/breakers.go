@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// backendBreakers are the circuit breakers of a backend , a single one or one per gRPC method (breaker.per_method)
+type backendBreakers struct {
+	backend  string
+	cfg      models.BreakerConfig
+	mu       sync.Mutex
+	breakers map[string]*CircuitBreaker // by breaker name
+}
+
+func newBackendBreakers(backend string, cfg models.BreakerConfig) *backendBreakers {
+	b := &backendBreakers{backend: backend, cfg: cfg, breakers: make(map[string]*CircuitBreaker)}
+	if !cfg.PerMethod {
+		// shown in the health output before the first call
+		b.get("")
+	}
+	return b
+}
+
+// get returns the breaker of a full gRPC method (ex: /post.PostSerive/CreatePost)
+func (b *backendBreakers) get(method string) *CircuitBreaker {
+	name := b.backend
+	if b.cfg.PerMethod {
+		name = b.backend + method
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cb, ok := b.breakers[name]
+	if !ok {
+		cb = NewRateCircuitBreaker(name, b.cfg)
+		b.breakers[name] = cb
+	}
+	return cb
+}
+
+// states of the breakers by name , for the health output
+func (b *backendBreakers) states() map[string]string {
+	b.mu.Lock()
+	breakers := maps.Clone(b.breakers)
+	b.mu.Unlock()
+	states := make(map[string]string, len(breakers))
+	for name, cb := range breakers {
+		states[name] = cb.State().String()
+	}
+	return states
+}
+
+// breakerFailure reports whether an error counts against the backend health.
+// Client errors (ex: InvalidArgument , NotFound) don`t.
+func breakerFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Internal, codes.Unknown, codes.DataLoss, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+// openError fails the call fast , sent to the client as 503 with Retry-After
+func openError(cb *CircuitBreaker) error {
+	seconds := int((cb.RetryAfter() + time.Second - 1) / time.Second)
+	return grpcError(codes.Unavailable, "CIRCUIT_OPEN", "Service not available", retryInfo(max(seconds, 1))).Err()
+}
+
+func (b *backendBreakers) record(cb *CircuitBreaker, ticket breakerTicket, err error, elapsed time.Duration) {
+	if status.Code(err) == codes.Canceled {
+		// the client went away , nothing is known about the backend
+		cb.Cancel(ticket)
+		return
+	}
+	cb.Record(ticket, breakerFailure(err), elapsed)
+}
+
+func (b *backendBreakers) unaryInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		cb := b.get(method)
+		ticket, ok := cb.Allow()
+		if !ok {
+			breakerRejections.WithLabelValues(cb.name).Inc()
+			return openError(cb)
+		}
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		b.record(cb, ticket, err, time.Since(start))
+		return err
+	}
+}
+
+// streamInterceptor fails the new streams fast while the breaker is open.
+// Only the stream creation is recorded (a stream lasts as long as the client wants) ,
+// a stream that is set up is a success , so a half-open breaker of a streaming only backend can close.
+func (b *backendBreakers) streamInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		cb := b.get(method)
+		ticket, ok := cb.Allow()
+		if !ok {
+			breakerRejections.WithLabelValues(cb.name).Inc()
+			return nil, openError(cb)
+		}
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		b.record(cb, ticket, err, 0)
+		if err != nil {
+			return nil, err
+		}
+		return stream, nil
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRateCircuitBreaker(t *testing.T) {
+	cb := NewRateCircuitBreaker("test", models.BreakerConfig{
+		Window: time.Second, MinRequests: 4, ErrorRate: 0.5,
+		SlowCall: 50 * time.Millisecond, SlowCallRate: 0.9, OpenTimeout: 20 * time.Millisecond,
+	})
+	call := func(failed bool, elapsed time.Duration) {
+		ticket, ok := cb.Allow()
+		if !ok {
+			t.Fatalf("%s breaker rejected a call", cb.State())
+		}
+		cb.Record(ticket, failed, elapsed)
+	}
+
+	// under min_requests nothing trips
+	call(true, 0)
+	call(true, 0)
+	call(false, 0)
+	if cb.State() != StateClosed {
+		t.Fatalf("state = %s , want closed", cb.State())
+	}
+	call(false, 0)
+	if cb.State() != StateOpen {
+		t.Fatalf("state = %s after 50%% errors , want open", cb.State())
+	}
+	if _, ok := cb.Allow(); ok {
+		t.Fatal("open breaker allowed a call")
+	}
+	if d := cb.RetryAfter(); d <= 0 || d > 20*time.Millisecond {
+		t.Errorf("RetryAfter = %v", d)
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	probe, ok := cb.Allow()
+	if _, again := cb.Allow(); !ok || again {
+		t.Fatal("half-open breaker must allow one probe")
+	}
+	// a slow probe opens it again
+	cb.Record(probe, false, 60*time.Millisecond)
+	if cb.State() != StateOpen {
+		t.Fatalf("state = %s after a slow probe , want open", cb.State())
+	}
+
+	time.Sleep(25 * time.Millisecond)
+	call(false, time.Millisecond)
+	if cb.State() != StateClosed {
+		t.Fatalf("state = %s after a good probe , want closed", cb.State())
+	}
+	// the old failures are forgotten
+	call(true, 0)
+	if cb.State() != StateClosed {
+		t.Fatalf("state = %s , want closed", cb.State())
+	}
+}
+
+func TestBreakerStaleOutcome(t *testing.T) {
+	cb := NewRateCircuitBreaker("test_stale", models.BreakerConfig{
+		Window: time.Second, MinRequests: 1, ErrorRate: 0.5, OpenTimeout: 10 * time.Millisecond,
+	})
+	// started while closed , done after the breaker went half-open
+	early, _ := cb.Allow()
+	hedged, _ := cb.Allow()
+	cb.Trip()
+	time.Sleep(15 * time.Millisecond)
+	probe, ok := cb.Allow()
+	if !ok {
+		t.Fatal("half-open breaker rejected the probe")
+	}
+
+	cb.Record(early, false, 0)
+	if cb.State() != StateHalfOpen {
+		t.Fatalf("state = %s after a pre-trip success , want half-open", cb.State())
+	}
+	cb.Cancel(hedged)
+	if _, ok := cb.Allow(); ok {
+		t.Fatal("a canceled pre-trip call let a second probe through")
+	}
+	cb.Record(probe, false, 0)
+	if cb.State() != StateClosed {
+		t.Fatalf("state = %s after the probe succeeded , want closed", cb.State())
+	}
+}
+
+func TestBreakerOpenResponse(t *testing.T) {
+	cb := NewRateCircuitBreaker("test_open", models.BreakerConfig{OpenTimeout: 3 * time.Second})
+	cb.Trip()
+
+	w := httptest.NewRecorder()
+	writeGRPCError(w, openError(cb))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d , want 503", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "3" {
+		t.Errorf("Retry-After = %q , want 3", got)
+	}
+}
+
+func TestBreakerFailure(t *testing.T) {
+	for code, want := range map[codes.Code]bool{
+		codes.Unavailable:      true,
+		codes.DeadlineExceeded: true,
+		codes.Internal:         true,
+		codes.NotFound:         false,
+		codes.InvalidArgument:  false,
+		codes.Unauthenticated:  false,
+	} {
+		if got := breakerFailure(status.Error(code, "")); got != want {
+			t.Errorf("breakerFailure(%s) = %v , want %v", code, got, want)
+		}
+	}
+}
+
+func TestBackendConfigYAML(t *testing.T) {
+	var services map[string]*models.BackendConfig
+	err := yaml.Unmarshal([]byte(`
+post_service: "post-service:50061"
+feed_service:
+  addr: "feed-service:50081"
+  breaker:
+    per_method: true
+    error_rate: 0.3
+`), &services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if services["post_service"].Addr != "post-service:50061" {
+		t.Errorf("post_service = %+v", services["post_service"])
+	}
+	feed := services["feed_service"]
+	if feed.Addr != "feed-service:50081" || !feed.Breaker.PerMethod || feed.Breaker.ErrorRate != 0.3 {
+		t.Errorf("feed_service = %+v", feed)
+	}
+}
+
+func TestBreakerStreamProbe(t *testing.T) {
+	b := newBackendBreakers("stream_backend", models.BreakerConfig{Window: time.Second, MinRequests: 1, ErrorRate: 0.5, OpenTimeout: 10 * time.Millisecond})
+	cb := b.get("/feed.FeedService/Watch")
+	cb.Trip()
+	time.Sleep(15 * time.Millisecond)
+
+	streamer := func(context.Context, *grpc.StreamDesc, *grpc.ClientConn, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+		return nil, nil
+	}
+	if _, err := b.streamInterceptor()(context.Background(), &grpc.StreamDesc{ServerStreams: true}, nil, "/feed.FeedService/Watch", streamer); err != nil {
+		t.Fatal(err)
+	}
+	if cb.State() != StateClosed {
+		t.Errorf("state = %s after a stream probe , want closed", cb.State())
+	}
+}
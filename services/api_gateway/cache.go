@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"github.com/redis/go-redis/v9"
+)
+
+// Results of gateway_cache_requests_total
+const (
+	cacheHit         = "hit"
+	cacheMiss        = "miss"
+	cacheBypass      = "bypass" // no-store / no-cache from the client or redis down
+	cacheNotModified = "not_modified"
+)
+
+const cacheTimeout = 500 * time.Millisecond
+
+// cacheMiddlewares are the cache and invalidate-cache steps of the route_options cache / invalidates
+// (unless the route lists them in its middlewares)
+func cacheMiddlewares(route *models.RouteConfig, configs []models.MiddlewareConfig) []models.MiddlewareConfig {
+	listed := func(name string) bool {
+		return slices.ContainsFunc(configs, func(c models.MiddlewareConfig) bool { return c.Name == name })
+	}
+	var extra []models.MiddlewareConfig
+	if route.Cache != nil && !listed("cache") {
+		extra = append(extra, models.MiddlewareConfig{Name: "cache", Params: map[string]string{
+			"ttl":      route.Cache.TTL.String(),
+			"per_user": strconv.FormatBool(route.Cache.PerUser),
+		}})
+	}
+	if len(route.Invalidates) > 0 && !listed("invalidate-cache") {
+		extra = append(extra, models.MiddlewareConfig{Name: "invalidate-cache", Params: map[string]string{
+			"routes": strings.Join(route.Invalidates, ","),
+		}})
+	}
+	return extra
+}
+
+// cache serves the GET responses from redis (params: ttl , per_user).
+// A hit or a matching If-None-Match (304) never reaches the backend , a miss stores the response.
+func newCacheMiddleware(params map[string]string) (*Middleware, error) {
+	ttl, err := time.ParseDuration(params["ttl"])
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("invalid ttl %q", params["ttl"])
+	}
+	perUser := params["per_user"] == "true"
+
+	return &Middleware{
+		Before: func(h *Handler, rc *requestContext) bool {
+			if rc.r.Method != http.MethodGet || h.redis == nil {
+				return true
+			}
+			directives := cacheDirectives(rc.r.Header.Get("Cache-Control"))
+			if directives["no-store"] {
+				cacheRequests.WithLabelValues(rc.route.Path, cacheBypass).Inc()
+				return true
+			}
+
+			ctx, cancel := context.WithTimeout(rc.r.Context(), cacheTimeout)
+			defer cancel()
+			key, err := cacheKey(ctx, h.redis, rc, perUser)
+			if err != nil {
+				slog.WarnContext(rc.r.Context(), "response cache unavailable", "error", err)
+				redisErrors.WithLabelValues(redisResponseCache).Inc()
+				cacheRequests.WithLabelValues(rc.route.Path, cacheBypass).Inc()
+				return true
+			}
+			rc.cacheKey = key
+			// no-cache (or max-age=0) asks for a fresh response , it is still stored
+			if directives["no-cache"] || directives["max-age=0"] {
+				cacheRequests.WithLabelValues(rc.route.Path, cacheBypass).Inc()
+				return true
+			}
+
+			value, err := h.redis.Get(ctx, key).Result()
+			if err != nil {
+				if !errors.Is(err, redis.Nil) {
+					redisErrors.WithLabelValues(redisResponseCache).Inc()
+				}
+				cacheRequests.WithLabelValues(rc.route.Path, cacheMiss).Inc()
+				return true
+			}
+			entry, ok := parseCacheEntry(value)
+			if !ok {
+				return true
+			}
+			age := time.Since(entry.storedAt)
+			setCacheHeaders(rc, entry.etag, max(ttl-age, 0), perUser)
+			rc.w.Header().Set("Age", strconv.Itoa(int(age.Seconds())))
+			rc.w.Header().Set("X-Cache", "HIT")
+			if etagMatch(rc.r.Header.Get("If-None-Match"), entry.etag) {
+				cacheRequests.WithLabelValues(rc.route.Path, cacheNotModified).Inc()
+				rc.w.WriteHeader(http.StatusNotModified)
+				return false
+			}
+			cacheRequests.WithLabelValues(rc.route.Path, cacheHit).Inc()
+			rc.w.Header().Set("Content-Type", "application/json")
+			rc.w.WriteHeader(http.StatusOK)
+			rc.w.Write(entry.body)
+			return false
+		},
+		After: func(h *Handler, rc *requestContext, response []byte) bool {
+			if rc.cacheKey == "" {
+				return true
+			}
+			sum := sha256.Sum256(response)
+			etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+			ctx, cancel := context.WithTimeout(rc.r.Context(), cacheTimeout)
+			defer cancel()
+			if err := h.redis.Set(ctx, rc.cacheKey, formatCacheEntry(etag, time.Now(), response), ttl).Err(); err != nil {
+				slog.WarnContext(rc.r.Context(), "failed to store the response", "error", err)
+				redisErrors.WithLabelValues(redisResponseCache).Inc()
+			}
+			setCacheHeaders(rc, etag, ttl, perUser)
+			rc.w.Header().Set("X-Cache", "MISS")
+			if etagMatch(rc.r.Header.Get("If-None-Match"), etag) {
+				rc.w.WriteHeader(http.StatusNotModified)
+				return false
+			}
+			return true
+		},
+	}, nil
+}
+
+// invalidate-cache empties the cached routes of params routes (comma separated paths) after a success of the route
+func newInvalidateCacheMiddleware(params map[string]string) (*Middleware, error) {
+	var routes []string
+	for _, path := range strings.Split(params["routes"], ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			routes = append(routes, path)
+		}
+	}
+	if len(routes) == 0 {
+		return nil, errors.New("no routes to invalidate")
+	}
+	return &Middleware{
+		After: func(h *Handler, rc *requestContext, response []byte) bool {
+			if h.redis == nil {
+				return true
+			}
+			ctx, cancel := context.WithTimeout(rc.r.Context(), cacheTimeout)
+			defer cancel()
+			pipe := h.redis.Pipeline()
+			for _, path := range routes {
+				pipe.Incr(ctx, cacheGenerationKey(path))
+			}
+			if _, err := pipe.Exec(ctx); err != nil {
+				// the entries live until their ttl
+				slog.ErrorContext(rc.r.Context(), "failed to invalidate the cached routes", "routes", routes, "error", err)
+				redisErrors.WithLabelValues(redisResponseCache).Inc()
+			}
+			return true
+		},
+	}, nil
+}
+
+// cacheGenerationKey is bumped to invalidate every entry of a route (the old keys expire with their ttl)
+func cacheGenerationKey(path string) string {
+	return "gw:cache:gen:" + path
+}
+
+// cacheKey is built from the route , its generation , the path params , the query and the user (per_user)
+func cacheKey(ctx context.Context, client *redis.Client, rc *requestContext, perUser bool) (string, error) {
+	generation, err := client.Get(ctx, cacheGenerationKey(rc.route.Path)).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", err
+	}
+
+	return fmt.Sprintf("gw:cache:%s:%s:%s", rc.route.Path, generation, requestHash(rc, perUser)), nil
+}
+
+// requestHash identifies the response of a route , the order of the query params doesn`t matter
+func requestHash(rc *requestContext, perUser bool) string {
+	hash := sha256.New()
+	for _, name := range slices.Sorted(maps.Keys(rc.pathParams)) {
+		fmt.Fprintf(hash, "%s=%s&", name, rc.pathParams[name])
+	}
+	// Encode sorts by key
+	fmt.Fprintf(hash, "?%s", rc.r.URL.Query().Encode())
+	if perUser {
+		fmt.Fprintf(hash, "#%s", rc.userID)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func setCacheHeaders(rc *requestContext, etag string, maxAge time.Duration, perUser bool) {
+	scope := "public"
+	if perUser || rc.route.RequireAuth {
+		scope = "private"
+	}
+	rc.w.Header().Set("ETag", etag)
+	rc.w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, int(maxAge.Seconds())))
+	if perUser {
+		rc.w.Header().Add("Vary", "Authorization, Cookie")
+	}
+}
+
+// cacheDirectives parses a Cache-Control header (ex: no-cache , max-age=0)
+func cacheDirectives(header string) map[string]bool {
+	directives := make(map[string]bool)
+	for _, d := range strings.Split(header, ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			directives[d] = true
+		}
+	}
+	return directives
+}
+
+// etagMatch is the weak comparison of If-None-Match
+func etagMatch(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+type cacheEntry struct {
+	etag     string
+	storedAt time.Time
+	body     []byte
+}
+
+// a cache entry is "etag\nstored at (unix ms)\nbody"
+func formatCacheEntry(etag string, storedAt time.Time, body []byte) string {
+	return etag + "\n" + strconv.FormatInt(storedAt.UnixMilli(), 10) + "\n" + string(body)
+}
+
+func parseCacheEntry(value string) (cacheEntry, bool) {
+	etag, rest, ok := strings.Cut(value, "\n")
+	if !ok {
+		return cacheEntry{}, false
+	}
+	stored, body, ok := strings.Cut(rest, "\n")
+	if !ok {
+		return cacheEntry{}, false
+	}
+	ms, err := strconv.ParseInt(stored, 10, 64)
+	if err != nil {
+		return cacheEntry{}, false
+	}
+	return cacheEntry{etag: etag, storedAt: time.UnixMilli(ms), body: []byte(body)}, true
+}
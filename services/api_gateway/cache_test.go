@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+)
+
+func TestCachePipeline(t *testing.T) {
+	route := &models.RouteConfig{Method: "GET", Path: "/api/v1/posts/{PostId}", RequireAuth: true, Cache: &models.CacheConfig{TTL: 30 * time.Second, PerUser: true}}
+	pipeline, err := buildPipeline(route, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// after the auth and the rate limits
+	want := []string{"cors", "csrf", "auth", "user-ratelimit", "cache"}
+	if got := pipelineNames(pipeline); !slices.Equal(got, want) {
+		t.Errorf("pipeline = %v , want %v", got, want)
+	}
+
+	route = &models.RouteConfig{Method: "DELETE", Path: "/api/v1/posts/{PostId}", Invalidates: []string{"/api/v1/posts/{PostId}"}}
+	pipeline, err = buildPipeline(route, []models.MiddlewareConfig{{Name: "csrf"}, {Name: "auth"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := pipelineNames(pipeline); !slices.Equal(got, []string{"csrf", "auth", "invalidate-cache"}) {
+		t.Errorf("pipeline = %v", got)
+	}
+
+	if _, err := buildPipeline(&models.RouteConfig{Cache: &models.CacheConfig{}}, nil); err == nil {
+		t.Error("cache without ttl accepted")
+	}
+	// one entry would be served to every user
+	route = &models.RouteConfig{Method: "GET", Path: "/api/v1/feed", RequireAuth: true, Cache: &models.CacheConfig{TTL: 10 * time.Second}}
+	if _, err := buildPipeline(route, nil); err == nil {
+		t.Error("shared cache accepted on an authenticated route")
+	}
+	route = &models.RouteConfig{Method: "GET", Path: "/api/v1/feed"}
+	configs := []models.MiddlewareConfig{{Name: "csrf"}, {Name: "auth"}, {Name: "cache", Params: map[string]string{"ttl": "10s"}}}
+	if _, err := buildPipeline(route, configs); err == nil {
+		t.Error("listed shared cache accepted after auth")
+	}
+}
+
+func TestRequestHash(t *testing.T) {
+	rc := func(target, user string) *requestContext {
+		return &requestContext{
+			r:          httptest.NewRequest("GET", target, nil),
+			pathParams: map[string]string{"UserId": "1"},
+			userID:     user,
+		}
+	}
+	if requestHash(rc("/a?x=1&y=2", "u1"), false) != requestHash(rc("/a?y=2&x=1", "u2"), false) {
+		t.Error("the query order or the user changed a shared key")
+	}
+	if requestHash(rc("/a?x=1", "u1"), true) == requestHash(rc("/a?x=1", "u2"), true) {
+		t.Error("per_user keys are shared")
+	}
+	if requestHash(rc("/a?x=1", ""), false) == requestHash(rc("/a?x=2", ""), false) {
+		t.Error("the query is not in the key")
+	}
+}
+
+func TestCacheEntryAndETag(t *testing.T) {
+	stored := time.UnixMilli(1700000000000)
+	entry, ok := parseCacheEntry(formatCacheEntry(`"abc"`, stored, []byte("{\"a\":\n1}")))
+	if !ok || entry.etag != `"abc"` || !entry.storedAt.Equal(stored) || string(entry.body) != "{\"a\":\n1}" {
+		t.Errorf("entry = %+v", entry)
+	}
+	if _, ok := parseCacheEntry("garbage"); ok {
+		t.Error("garbage parsed")
+	}
+
+	for header, want := range map[string]bool{
+		`"abc"`:      true,
+		`W/"abc"`:    true,
+		`"x", "abc"`: true,
+		`*`:          true,
+		`"other"`:    false,
+		``:           false,
+	} {
+		if got := etagMatch(header, `"abc"`); got != want {
+			t.Errorf("etagMatch(%q) = %v , want %v", header, got, want)
+		}
+	}
+
+	d := cacheDirectives("No-Cache, max-age=0")
+	if !d["no-cache"] || !d["max-age=0"] || d["no-store"] {
+		t.Errorf("directives = %v", d)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+)
+
+type BreakerState int
+
+const (
+	StateClosed   BreakerState = iota // requests go through
+	StateOpen                         // requests fail fast
+	StateHalfOpen                     // one probe is allowed to test recovery
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreaker trips after N consecutive failures (or on the rates of a rolling window , see NewRateCircuitBreaker)
+// and stays open for openTimeout. After that a single probe is allowed, if it succeeds the breaker closes again.
+type CircuitBreaker struct {
+	name             string
+	mu               sync.Mutex
+	state            BreakerState
+	failures         int
+	failureThreshold int
+	openTimeout      time.Duration
+	openedAt         time.Time
+	probing          bool
+	generation       uint64 // bumped on every state change , see breakerTicket
+
+	// rate mode (Record) , used for the backends
+	window       *rollingWindow
+	minRequests  int
+	errorRate    float64
+	slowCall     time.Duration
+	slowCallRate float64
+}
+
+func NewCircuitBreaker(name string, failureThreshold int, openTimeout time.Duration) *CircuitBreaker {
+	if failureThreshold <= 0 {
+		failureThreshold = 5
+	}
+	if openTimeout <= 0 {
+		openTimeout = 10 * time.Second
+	}
+	breakerState.WithLabelValues(name).Set(float64(StateClosed))
+	return &CircuitBreaker{
+		name:             name,
+		state:            StateClosed,
+		failureThreshold: failureThreshold,
+		openTimeout:      openTimeout,
+	}
+}
+
+// NewRateCircuitBreaker trips when the failed calls or the slow calls of the window reach their rate
+// (once the window has cfg.MinRequests calls). The calls report their outcome with Record.
+func NewRateCircuitBreaker(name string, cfg models.BreakerConfig) *CircuitBreaker {
+	cb := NewCircuitBreaker(name, 0, cfg.OpenTimeout)
+	cb.window = newRollingWindow(cfg.Window, 10)
+	cb.minRequests = cfg.MinRequests
+	cb.errorRate = cfg.ErrorRate
+	cb.slowCall = cfg.SlowCall
+	cb.slowCallRate = cfg.SlowCallRate
+	return cb
+}
+
+// breakerTicket is handed to an allowed call and given back with its outcome.
+// A call admitted before the last state change (ex: started while closed , done while half-open)
+// says nothing about the current state , its outcome is ignored.
+type breakerTicket struct {
+	generation uint64
+	probe      bool // the single call of the half-open state
+}
+
+// Allow reports whether the call should go to the protected resource
+func (cb *CircuitBreaker) Allow() (breakerTicket, bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case StateOpen:
+		if time.Since(cb.openedAt) < cb.openTimeout {
+			return breakerTicket{}, false
+		}
+		cb.setState(StateHalfOpen)
+		cb.probing = true
+		return breakerTicket{generation: cb.generation, probe: true}, true
+	case StateHalfOpen:
+		// only one probe at a time
+		if cb.probing {
+			return breakerTicket{}, false
+		}
+		cb.probing = true
+		return breakerTicket{generation: cb.generation, probe: true}, true
+	}
+	return breakerTicket{generation: cb.generation}, true
+}
+
+// current reports whether the outcome of the ticket applies to the current state
+func (cb *CircuitBreaker) current(t breakerTicket) bool {
+	if t.generation != cb.generation {
+		return false
+	}
+	if t.probe {
+		cb.probing = false
+	}
+	return true
+}
+
+func (cb *CircuitBreaker) Success(t breakerTicket) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if !cb.current(t) {
+		return
+	}
+	cb.failures = 0
+	if cb.state != StateClosed {
+		cb.setState(StateClosed)
+	}
+}
+
+func (cb *CircuitBreaker) Failure(t breakerTicket) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if !cb.current(t) {
+		return
+	}
+	cb.failures++
+	if cb.state == StateHalfOpen || cb.failures >= cb.failureThreshold {
+		cb.trip()
+	}
+}
+
+// Record reports the outcome of a call in rate mode
+func (cb *CircuitBreaker) Record(t breakerTicket, failed bool, elapsed time.Duration) {
+	slow := cb.slowCall > 0 && elapsed >= cb.slowCall
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if !cb.current(t) {
+		return
+	}
+	if cb.state == StateHalfOpen {
+		if failed || slow {
+			cb.trip()
+			return
+		}
+		cb.window.reset()
+		cb.setState(StateClosed)
+		return
+	}
+
+	now := time.Now()
+	cb.window.add(now, failed, slow)
+	total, failures, slows := cb.window.sum(now)
+	if total < cb.minRequests {
+		return
+	}
+	if float64(failures)/float64(total) >= cb.errorRate ||
+		(cb.slowCall > 0 && float64(slows)/float64(total) >= cb.slowCallRate) {
+		cb.trip()
+	}
+}
+
+// Cancel frees the probe of a call that has no outcome (ex: canceled by the client , a lost hedged call)
+func (cb *CircuitBreaker) Cancel(t breakerTicket) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.current(t)
+}
+
+// RetryAfter is the time left before a probe is let through
+func (cb *CircuitBreaker) RetryAfter() time.Duration {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state != StateOpen {
+		return 0
+	}
+	return max(cb.openTimeout-time.Since(cb.openedAt), 0)
+}
+
+// Trip opens the breaker directly (ex: resource is down at startup)
+func (cb *CircuitBreaker) Trip() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.trip()
+}
+
+func (cb *CircuitBreaker) trip() {
+	cb.openedAt = time.Now()
+	if cb.state != StateOpen {
+		cb.setState(StateOpen)
+	}
+}
+
+func (cb *CircuitBreaker) State() BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+func (cb *CircuitBreaker) setState(state BreakerState) {
+	slog.Warn("circuit breaker state changed", "breaker", cb.name, "from", cb.state.String(), "to", state.String())
+	cb.state = state
+	cb.generation++
+	breakerState.WithLabelValues(cb.name).Set(float64(state))
+	breakerTransitions.WithLabelValues(cb.name, state.String()).Inc()
+}
+
+// rollingWindow counts the calls of the last buckets (the oldest bucket is reused)
+type rollingWindow struct {
+	bucketSize time.Duration
+	buckets    []windowBucket
+}
+
+type windowBucket struct {
+	index    int64 // start time / bucketSize
+	total    int
+	failures int
+	slow     int
+}
+
+func newRollingWindow(size time.Duration, buckets int) *rollingWindow {
+	if size <= 0 {
+		size = 10 * time.Second
+	}
+	return &rollingWindow{bucketSize: max(size/time.Duration(buckets), time.Millisecond), buckets: make([]windowBucket, buckets)}
+}
+
+func (w *rollingWindow) add(now time.Time, failed, slow bool) {
+	index := now.UnixNano() / int64(w.bucketSize)
+	b := &w.buckets[index%int64(len(w.buckets))]
+	if b.index != index {
+		*b = windowBucket{index: index}
+	}
+	b.total++
+	if failed {
+		b.failures++
+	}
+	if slow {
+		b.slow++
+	}
+}
+
+func (w *rollingWindow) sum(now time.Time) (total, failures, slow int) {
+	index := now.UnixNano() / int64(w.bucketSize)
+	for _, b := range w.buckets {
+		if index-b.index < int64(len(w.buckets)) {
+			total += b.total
+			failures += b.failures
+			slow += b.slow
+		}
+	}
+	return total, failures, slow
+}
+
+func (w *rollingWindow) reset() {
+	clear(w.buckets)
+}
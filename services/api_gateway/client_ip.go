@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// NOTE: the peer of the gateway is the load balancer / ingress , not the client.
+// Forwarding headers are set by whoever sent the request , so they are only read
+// when the peer is a trusted proxy , and only the one header that proxy writes
+// (server.client_ip_header) : a proxy appends to its own header and passes the
+// others through untouched , so any other header is whatever the client sent.
+// The chain is walked from the right
+// (the hops we trust) until the first untrusted address , which is the client.
+// IPv6 clients are keyed by their /64 , a single host usually owns the whole prefix.
+
+// ipv6ClientBits is the prefix an IPv6 client is normalized to
+const ipv6ClientBits = 64
+
+// forwarding headers a trusted proxy can be configured with (server.client_ip_header)
+const (
+	ClientIPHeaderXForwardedFor = "x-forwarded-for"
+	ClientIPHeaderForwarded     = "forwarded"
+	ClientIPHeaderXRealIP       = "x-real-ip"
+)
+
+type clientIPResolver struct {
+	trusted []netip.Prefix
+	header  string
+}
+
+func newClientIPResolver(header string, cidrs []string) (*clientIPResolver, error) {
+	header, err := parseClientIPHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	resolver := &clientIPResolver{header: header}
+	for _, cidr := range cidrs {
+		prefix, err := parseTrustedProxy(cidr)
+		if err != nil {
+			return nil, err
+		}
+		resolver.trusted = append(resolver.trusted, prefix)
+	}
+	return resolver, nil
+}
+
+// parseClientIPHeader accepts the header name in any case , ex: X-Forwarded-For
+func parseClientIPHeader(header string) (string, error) {
+	header = strings.ToLower(strings.TrimSpace(header))
+	switch header {
+	case ClientIPHeaderXForwardedFor, ClientIPHeaderForwarded, ClientIPHeaderXRealIP:
+		return header, nil
+	}
+	return "", fmt.Errorf("invalid client ip header %q , expected %s , %s or %s",
+		header, ClientIPHeaderXForwardedFor, ClientIPHeaderForwarded, ClientIPHeaderXRealIP)
+}
+
+// parseTrustedProxy accepts a CIDR or a single address
+func parseTrustedProxy(cidr string) (netip.Prefix, error) {
+	cidr = strings.TrimSpace(cidr)
+	if !strings.Contains(cidr, "/") {
+		addr, err := netip.ParseAddr(cidr)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid trusted proxy %q: %w", cidr, err)
+	}
+	if prefix.Addr().Is4In6() {
+		// ex: ::ffff:10.0.0.0/104 , peers are unmapped before matching
+		bits := prefix.Bits() - 96
+		if bits < 0 {
+			return netip.Prefix{}, fmt.Errorf("invalid trusted proxy %q: mapped prefix is wider than IPv4", cidr)
+		}
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), bits)
+	}
+	return prefix.Masked(), nil
+}
+
+func (c *clientIPResolver) isTrusted(addr netip.Addr) bool {
+	for _, prefix := range c.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the normalized address of the client , or "" if the peer is not an ip
+func (c *clientIPResolver) resolve(r *http.Request) string {
+	peer, ok := parseIP(r.RemoteAddr)
+	if !ok {
+		return ""
+	}
+	if !c.isTrusted(peer) {
+		return normalizeIP(peer)
+	}
+	return normalizeIP(c.walk(peer, forwardedChain(r.Header, c.header)))
+}
+
+// walk goes through the chain from the closest hop , a hop that can`t be parsed
+// is not trusted to say anything about the hops before it
+func (c *clientIPResolver) walk(peer netip.Addr, chain []string) netip.Addr {
+	client := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, ok := parseIP(chain[i])
+		if !ok {
+			return client
+		}
+		client = addr
+		if !c.isTrusted(addr) {
+			return client
+		}
+	}
+	// every hop is a proxy , the leftmost is the closest we have to the client
+	return client
+}
+
+// forwardedChain returns the addresses of the configured header ,
+// ordered from the client to the closest proxy , the other headers are ignored
+func forwardedChain(h http.Header, header string) []string {
+	var chain []string
+	switch header {
+	case ClientIPHeaderForwarded:
+		for _, value := range h.Values("Forwarded") {
+			for _, element := range strings.Split(value, ",") {
+				chain = append(chain, forwardedFor(element))
+			}
+		}
+	case ClientIPHeaderXForwardedFor:
+		for _, value := range h.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(value, ",") {
+				chain = append(chain, strings.TrimSpace(hop))
+			}
+		}
+	case ClientIPHeaderXRealIP:
+		if value := h.Get("X-Real-IP"); value != "" {
+			chain = append(chain, strings.TrimSpace(value))
+		}
+	}
+	return chain
+}
+
+// forwardedFor extracts the for= node of a Forwarded element (RFC 7239) ,
+// ex: for="[2001:db8::1]:4711";proto=https
+func forwardedFor(element string) string {
+	for _, pair := range strings.Split(element, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if ok && strings.EqualFold(key, "for") {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
+
+// parseIP parses an address with or without a port , ex: 1.2.3.4:80 , [::1]:80 , ::1
+func parseIP(s string) (netip.Addr, bool) {
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr().WithZone("").Unmap(), true
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	// zones are local to the proxy that saw them
+	return addr.WithZone("").Unmap(), true
+}
+
+// normalizeIP keys IPv6 clients by their /64 , ex: 2001:db8:1:2::/64
+func normalizeIP(addr netip.Addr) string {
+	if addr.Is6() {
+		prefix, _ := addr.Prefix(ipv6ClientBits)
+		return prefix.String()
+	}
+	return addr.String()
+}
+
+type clientIPKey struct{}
+
+func withClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+func clientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// clientIP is the address resolved by the gateway , the peer if it was not resolved
+func clientIP(r *http.Request) string {
+	if ip := clientIPFromContext(r.Context()); ip != "" {
+		return ip
+	}
+	if addr, ok := parseIP(r.RemoteAddr); ok {
+		return normalizeIP(addr)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+)
+
+func TestClientIPResolver(t *testing.T) {
+	trusted := []string{"10.0.0.0/8", "fd00::/8", "192.0.2.1"}
+	tests := []struct {
+		name    string
+		header  string
+		peer    string
+		headers map[string]string
+		want    string
+	}{
+		{"untrusted peer , port stripped", ClientIPHeaderXForwardedFor, "203.0.113.7:41234", nil, "203.0.113.7"},
+		{"untrusted peer ignores headers", ClientIPHeaderXForwardedFor, "203.0.113.7:41234", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "203.0.113.7"},
+		{"trusted peer without headers", ClientIPHeaderXForwardedFor, "10.1.2.3:5000", nil, "10.1.2.3"},
+		{"x-forwarded-for", ClientIPHeaderXForwardedFor, "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"spoofed hop is skipped", ClientIPHeaderXForwardedFor, "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.1, 10.9.9.9"}, "198.51.100.1"},
+		{"all hops trusted", ClientIPHeaderXForwardedFor, "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "10.0.0.5, 192.0.2.1"}, "10.0.0.5"},
+		{"invalid hop stops the walk", ClientIPHeaderXForwardedFor, "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "198.51.100.1, garbage, 10.0.0.5"}, "10.0.0.5"},
+		{"spoofed forwarded next to a real x-forwarded-for", ClientIPHeaderXForwardedFor, "10.1.2.3:5000", map[string]string{
+			"Forwarded":       "for=1.1.1.1",
+			"X-Forwarded-For": "198.51.100.1",
+		}, "198.51.100.1"},
+		{"spoofed x-real-ip is ignored", ClientIPHeaderXForwardedFor, "10.1.2.3:5000", map[string]string{"X-Real-IP": "1.1.1.1"}, "10.1.2.3"},
+		{"x-real-ip", ClientIPHeaderXRealIP, "10.1.2.3:5000", map[string]string{"X-Real-IP": "198.51.100.2"}, "198.51.100.2"},
+		{"forwarded", ClientIPHeaderForwarded, "10.1.2.3:5000", map[string]string{
+			"Forwarded":       `for=198.51.100.3;proto=https, for="10.0.0.7:80"`,
+			"X-Forwarded-For": "1.1.1.1",
+		}, "198.51.100.3"},
+		{"forwarded ipv6 with port", ClientIPHeaderForwarded, "10.1.2.3:5000", map[string]string{"Forwarded": `for="[2001:db8:1:2:3::4]:4711"`}, "2001:db8:1:2::/64"},
+		{"ipv6 peer normalized", ClientIPHeaderXForwardedFor, "[2001:db8:a:b:c::1]:443", nil, "2001:db8:a:b::/64"},
+		{"trusted ipv6 peer", ClientIPHeaderXForwardedFor, "[fd00::1]:443", map[string]string{"X-Forwarded-For": "::ffff:198.51.100.4"}, "198.51.100.4"},
+	}
+	for _, tt := range tests {
+		resolver, err := newClientIPResolver(tt.header, trusted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.peer
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		if got := resolver.resolve(r); got != tt.want {
+			t.Errorf("%s: resolve = %q , want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseClientIPHeader(t *testing.T) {
+	if got, err := parseClientIPHeader(" X-Forwarded-For "); err != nil || got != ClientIPHeaderXForwardedFor {
+		t.Errorf("parseClientIPHeader = %q , %v , want %s", got, err, ClientIPHeaderXForwardedFor)
+	}
+	for _, header := range []string{"", "x-client-ip", "forwarded, x-forwarded-for"} {
+		if _, err := parseClientIPHeader(header); err == nil {
+			t.Errorf("client ip header %q is accepted", header)
+		}
+	}
+}
+
+func TestClientIPConsistent(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "203.0.113.7:41234"
+	if got := ipExtractor(r); got != "203.0.113.7" {
+		t.Errorf("unresolved ipExtractor = %q , want the peer host", got)
+	}
+	r = r.WithContext(withClientIP(r.Context(), "198.51.100.1"))
+	if got := ipExtractor(r); got != "198.51.100.1" {
+		t.Errorf("ipExtractor = %q , want the resolved ip", got)
+	}
+	md := identityMetadata(models.IdentityConfig{Forward: []string{ForwardClientIP}}, &requestContext{r: r})
+	if got := md.Get("x-client-ip"); len(got) != 1 || got[0] != "198.51.100.1" {
+		t.Errorf("x-client-ip = %v , want the resolved ip", got)
+	}
+}
+
+func TestParseTrustedProxy(t *testing.T) {
+	for in, want := range map[string]string{
+		"10.1.0.0/16":         "10.1.0.0/16",
+		"10.1.2.3/16":         "10.1.0.0/16",
+		"192.0.2.1":           "192.0.2.1/32",
+		"::ffff:10.0.0.0/104": "10.0.0.0/8",
+		"fd00::1":             "fd00::1/128",
+	} {
+		got, err := parseTrustedProxy(in)
+		if err != nil || got.String() != want {
+			t.Errorf("parseTrustedProxy(%q) = %v , %v , want %s", in, got, err, want)
+		}
+	}
+	if _, err := parseTrustedProxy("not-a-cidr"); err == nil {
+		t.Error("invalid cidr is accepted")
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// coalesce shares one backend call (and its response) between the identical in-flight requests
+// of a shareable route , ex: thousands of clients reading the same post at once
+func (h *Handler) coalesce(ctx context.Context, st *gatewayState, conn *grpc.ClientConn, route *models.RouteConfig, reqMsg proto.Message) ([]byte, error) {
+	if !route.Shareable {
+		return h.invoke(ctx, st, conn, route, reqMsg)
+	}
+	key, ok := shareKey(route, reqMsg)
+	if md, _ := metadata.FromOutgoingContext(ctx); !ok || forwardsUser(md) {
+		return h.invoke(ctx, st, conn, route, reqMsg)
+	}
+
+	results := h.inflight.DoChan(key, func() (any, error) {
+		// the call must not end with the request that started it , the others still wait for it
+		return h.invoke(context.WithoutCancel(ctx), st, conn, route, reqMsg)
+	})
+	select {
+	case res := <-results:
+		if res.Shared {
+			coalescedRequests.WithLabelValues(route.Path).Inc()
+		}
+		response, _ := res.Val.([]byte)
+		return response, res.Err
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// shareKey is the backend method and the normalized request JSON.
+// Requests that carry user data set by the gateway (see injectedFields , identityMetadata) are never shared.
+func shareKey(route *models.RouteConfig, reqMsg proto.Message) (string, bool) {
+	msg := reqMsg.ProtoReflect()
+	for name := range gatewayFields {
+		if fd := findField(msg.Descriptor(), name); fd != nil && msg.Has(fd) {
+			return "", false
+		}
+	}
+	body, err := protojson.Marshal(reqMsg)
+	if err != nil {
+		return "", false
+	}
+	return route.BackendService + "/" + route.GRPCService + "/" + route.GRPCMethod + "\n" + string(body), true
+}
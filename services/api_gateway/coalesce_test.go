@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// testRequest is a message with a PostId and the UserId set by the gateway
+func testRequest(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+	field := func(name string, number int32) *descriptorpb.FieldDescriptorProto {
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+	}
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("coalesce_test.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name:  proto.String("GetPostRequest"),
+			Field: []*descriptorpb.FieldDescriptorProto{field("PostId", 1), field("UserId", 2)},
+		}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file.Messages().Get(0)
+}
+
+func TestShareKey(t *testing.T) {
+	desc := testRequest(t)
+	route := &models.RouteConfig{BackendService: "post_service", GRPCService: "post.PostService", GRPCMethod: "GetPost"}
+	request := func(postID, userID string) proto.Message {
+		msg := dynamicpb.NewMessage(desc)
+		msg.Set(desc.Fields().ByName("PostId"), protoreflect.ValueOfString(postID))
+		if userID != "" {
+			msg.Set(desc.Fields().ByName("UserId"), protoreflect.ValueOfString(userID))
+		}
+		return msg
+	}
+
+	a, ok := shareKey(route, request("42", ""))
+	b, _ := shareKey(route, request("42", ""))
+	c, _ := shareKey(route, request("43", ""))
+	if !ok || a != b || a == c {
+		t.Errorf("keys %q , %q , %q", a, b, c)
+	}
+	if _, ok := shareKey(route, request("42", "7")); ok {
+		t.Error("a request with the user id must not be shared")
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+)
+
+// Token cookies set on login/refresh so browsers can authenticate without JS access to the tokens
+const (
+	accessTokenCookie  = "accessToken"
+	refreshTokenCookie = "refreshToken"
+)
+
+// requestTokens are the tokens of a request and where they were found
+type requestTokens struct {
+	access            string
+	refresh           string
+	accessFromCookie  bool
+	refreshFromCookie bool
+}
+
+// extractTokens reads the tokens from the headers , or from the cookies for browsers.
+// Headers win so API clients are never subject to the CSRF check.
+func extractTokens(r *http.Request) requestTokens {
+	var tokens requestTokens
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		tokens.access = strings.TrimPrefix(authHeader, "Bearer ")
+	} else if c, err := r.Cookie(accessTokenCookie); err == nil && c.Value != "" {
+		tokens.access = c.Value
+		tokens.accessFromCookie = true
+	}
+
+	if token := r.Header.Get("refreshToken"); token != "" {
+		tokens.refresh = token
+	} else if c, err := r.Cookie(refreshTokenCookie); err == nil && c.Value != "" {
+		tokens.refresh = c.Value
+		tokens.refreshFromCookie = true
+	}
+	return tokens
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// needsCSRF reports if a request is authenticated by the browser cookies of the route
+// (the access cookie on auth routes , the refresh cookie is only sent to its own paths)
+func needsCSRF(r *http.Request, route *models.RouteConfig, tokens requestTokens) bool {
+	if !isMutating(r.Method) {
+		return false
+	}
+	return (route.RequireAuth && tokens.accessFromCookie) || tokens.refreshFromCookie
+}
+
+// checkCSRF is the double-submit check: the header must match the csrf cookie set on login.
+// A cross site page can make the browser send the cookies but can`t read them to set the header.
+func checkCSRF(r *http.Request, cfg models.AuthConfig) bool {
+	c, err := r.Cookie(cfg.CSRFCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	header := r.Header.Get(cfg.CSRFHeader)
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(header)) == 1
+}
+
+func newCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// cookieSameSite lets the cookies follow the cross site calls of the frontend , but browsers drop
+// a SameSite=None cookie that is not Secure , so plain http (local runs) falls back to Lax
+func cookieSameSite(cfg models.AuthConfig) http.SameSite {
+	if cfg.SecureCookies {
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteLaxMode
+}
+
+// setTokenCookies sets the access cookie for the whole API , the refresh cookie only on the
+// refresh_cookie_paths (one cookie per path) and a new csrf token readable by the frontend JS
+func setTokenCookies(w http.ResponseWriter, tokens models.Tokens, cfg models.AuthConfig) error {
+	csrf, err := newCSRFToken()
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessTokenCookie,
+		Value:    tokens.Access,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: cookieSameSite(cfg),
+		Secure:   cfg.SecureCookies, // required in production for https
+	})
+	for _, path := range cfg.RefreshCookiePaths {
+		http.SetCookie(w, &http.Cookie{
+			Name:     refreshTokenCookie,
+			Value:    tokens.Refresh,
+			Path:     path,
+			HttpOnly: true,
+			SameSite: cookieSameSite(cfg),
+			Secure:   cfg.SecureCookies,
+		})
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     cfg.CSRFCookie,
+		Value:    csrf,
+		Path:     "/",
+		HttpOnly: false, // the frontend copies it to the csrf header
+		SameSite: cookieSameSite(cfg),
+		Secure:   cfg.SecureCookies,
+	})
+	w.Header().Set(cfg.CSRFHeader, csrf)
+	return nil
+}
+
+// clearTokenCookies expires every cookie set by setTokenCookies (on logout)
+func clearTokenCookies(w http.ResponseWriter, cfg models.AuthConfig) {
+	expire := func(name, path string) {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     path,
+			MaxAge:   -1,
+			HttpOnly: name != cfg.CSRFCookie,
+			SameSite: cookieSameSite(cfg),
+			Secure:   cfg.SecureCookies,
+		})
+	}
+	expire(accessTokenCookie, "/")
+	for _, path := range cfg.RefreshCookiePaths {
+		expire(refreshTokenCookie, path)
+	}
+	expire(cfg.CSRFCookie, "/")
+}
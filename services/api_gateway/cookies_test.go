@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+)
+
+func TestCSRF(t *testing.T) {
+	cfg := models.AuthConfig{CSRFCookie: "csrfToken", CSRFHeader: "X-CSRF-Token", RefreshCookiePaths: []string{"/api/v1/refresh"}}
+	authRoute := &models.RouteConfig{RequireAuth: true}
+
+	newRequest := func(method, header string) *http.Request {
+		r := httptest.NewRequest(method, "/api/v1/posts/post", nil)
+		r.AddCookie(&http.Cookie{Name: accessTokenCookie, Value: "jwt"})
+		r.AddCookie(&http.Cookie{Name: "csrfToken", Value: "abc"})
+		if header != "" {
+			r.Header.Set("X-CSRF-Token", header)
+		}
+		return r
+	}
+
+	bearer := newRequest("POST", "")
+	bearer.Header.Set("Authorization", "Bearer jwt")
+
+	tests := []struct {
+		name    string
+		r       *http.Request
+		blocked bool
+	}{
+		{"cookie POST with matching header", newRequest("POST", "abc"), false},
+		{"cookie POST without header", newRequest("POST", ""), true},
+		{"cookie POST with wrong header", newRequest("POST", "xyz"), true},
+		{"cookie GET", newRequest("GET", ""), false},
+		{"Authorization header POST", bearer, false},
+	}
+
+	for _, tt := range tests {
+		tokens := extractTokens(tt.r)
+		if tokens.access != "jwt" {
+			t.Errorf("%s: access token = %q", tt.name, tokens.access)
+		}
+		blocked := needsCSRF(tt.r, authRoute, tokens) && !checkCSRF(tt.r, cfg)
+		if blocked != tt.blocked {
+			t.Errorf("%s: blocked = %v, want %v", tt.name, blocked, tt.blocked)
+		}
+	}
+}
+
+func TestSetTokenCookiesScopesRefresh(t *testing.T) {
+	cfg := models.AuthConfig{CSRFCookie: "csrfToken", CSRFHeader: "X-CSRF-Token", RefreshCookiePaths: []string{"/api/v1/refresh", "/api/v1/logout"}}
+	w := httptest.NewRecorder()
+	if err := setTokenCookies(w, models.Tokens{Access: "a", Refresh: "r"}, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	var refreshPaths []string
+	for _, c := range w.Result().Cookies() {
+		switch c.Name {
+		case refreshTokenCookie:
+			refreshPaths = append(refreshPaths, c.Path)
+		case "csrfToken":
+			if c.HttpOnly || c.Value == "" || w.Header().Get("X-CSRF-Token") != c.Value {
+				t.Errorf("csrf cookie must be readable and match the header, got %+v", c)
+			}
+		}
+	}
+	if len(refreshPaths) != 2 || refreshPaths[0] != "/api/v1/refresh" || refreshPaths[1] != "/api/v1/logout" {
+		t.Errorf("refresh cookie paths = %v", refreshPaths)
+	}
+}
+
+func TestCookieSameSite(t *testing.T) {
+	for secure, want := range map[bool]http.SameSite{true: http.SameSiteNoneMode, false: http.SameSiteLaxMode} {
+		w := httptest.NewRecorder()
+		cfg := models.AuthConfig{CSRFCookie: "csrfToken", CSRFHeader: "X-CSRF-Token", SecureCookies: secure}
+		if err := setTokenCookies(w, models.Tokens{Access: "a", Refresh: "r"}, cfg); err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range w.Result().Cookies() {
+			// browsers drop SameSite=None without Secure
+			if c.SameSite != want || c.Secure != secure {
+				t.Errorf("secure_cookies %v: %s SameSite = %v , Secure = %v", secure, c.Name, c.SameSite, c.Secure)
+			}
+		}
+	}
+}
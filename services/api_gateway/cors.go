@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+)
+
+// corsPolicy is a CORSConfig ready to be checked on every request
+type corsPolicy struct {
+	anyOrigin   bool
+	origins     map[string]bool
+	wildcards   [][2]string // prefix , suffix around the "*"
+	methods     map[string]bool
+	anyHeader   bool
+	headers     map[string]bool // lower case
+	allowMethod string
+	allowHeader string
+	expose      string
+	maxAge      string
+	credentials bool
+}
+
+func newCORSPolicy(cfg models.CORSConfig) (*corsPolicy, error) {
+	p := &corsPolicy{
+		origins:     make(map[string]bool),
+		methods:     make(map[string]bool),
+		headers:     make(map[string]bool),
+		allowMethod: strings.Join(cfg.AllowedMethods, ", "),
+		allowHeader: strings.Join(cfg.AllowedHeaders, ", "),
+		expose:      strings.Join(cfg.ExposedHeaders, ", "),
+		credentials: cfg.AllowCredentials != nil && *cfg.AllowCredentials,
+	}
+	if cfg.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	}
+
+	for _, origin := range cfg.AllowedOrigins {
+		switch n := strings.Count(origin, "*"); {
+		case origin == "*":
+			p.anyOrigin = true
+		case n == 0:
+			p.origins[strings.ToLower(origin)] = true
+		case n == 1:
+			prefix, suffix, _ := strings.Cut(strings.ToLower(origin), "*")
+			p.wildcards = append(p.wildcards, [2]string{prefix, suffix})
+		default:
+			return nil, fmt.Errorf("cors origin %q: only one wildcard is allowed", origin)
+		}
+	}
+	// any site could read the responses with the user cookies
+	if p.anyOrigin && p.credentials {
+		return nil, fmt.Errorf("cors origin \"*\" can`t be used with allow_credentials , list the origins or set allow_credentials: false")
+	}
+	for _, method := range cfg.AllowedMethods {
+		p.methods[strings.ToUpper(method)] = true
+	}
+	for _, header := range cfg.AllowedHeaders {
+		if header == "*" {
+			p.anyHeader = true
+		}
+		p.headers[strings.ToLower(header)] = true
+	}
+	return p, nil
+}
+
+// mergeCORS returns the global config with the fields set by the route override
+func mergeCORS(global models.CORSConfig, override *models.CORSConfig) models.CORSConfig {
+	if override == nil {
+		return global
+	}
+	merged := global
+	if override.AllowedOrigins != nil {
+		merged.AllowedOrigins = override.AllowedOrigins
+	}
+	if override.AllowedMethods != nil {
+		merged.AllowedMethods = override.AllowedMethods
+	}
+	if override.AllowedHeaders != nil {
+		merged.AllowedHeaders = override.AllowedHeaders
+	}
+	if override.ExposedHeaders != nil {
+		merged.ExposedHeaders = override.ExposedHeaders
+	}
+	if override.MaxAge != 0 {
+		merged.MaxAge = override.MaxAge
+	}
+	if override.AllowCredentials != nil {
+		merged.AllowCredentials = override.AllowCredentials
+	}
+	return merged
+}
+
+func (p *corsPolicy) allowOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+	for _, w := range p.wildcards {
+		// the wildcard matches one or more subdomain labels , never a path or a port
+		if len(origin) > len(w[0])+len(w[1]) && strings.HasPrefix(origin, w[0]) && strings.HasSuffix(origin, w[1]) &&
+			!strings.ContainsAny(origin[len(w[0]):len(origin)-len(w[1])], "/:") {
+			return true
+		}
+	}
+	return false
+}
+
+// setOrigin allows the origin of the request.
+// "*" is only used without credentials (newCORSPolicy rejects both) , a listed origin is echoed.
+func (p *corsPolicy) setOrigin(w http.ResponseWriter, origin string) {
+	if p.anyOrigin {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Add("Vary", "Origin")
+	if p.credentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// apply sets the CORS headers of an actual (non preflight) request
+func (p *corsPolicy) apply(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	if !p.allowOrigin(origin) {
+		w.Header().Add("Vary", "Origin")
+		return
+	}
+	p.setOrigin(w, origin)
+	if p.expose != "" {
+		w.Header().Set("Access-Control-Expose-Headers", p.expose)
+	}
+}
+
+// preflight answers an OPTIONS preflight. Without the allow headers the browser blocks the request.
+func (p *corsPolicy) preflight(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Origin")
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+	defer w.WriteHeader(http.StatusNoContent)
+
+	origin := r.Header.Get("Origin")
+	if p == nil || origin == "" || !p.allowOrigin(origin) {
+		return
+	}
+	if !p.methods[strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))] {
+		return
+	}
+	if !p.anyHeader {
+		for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+			header = strings.ToLower(strings.TrimSpace(header))
+			if header != "" && !p.headers[header] {
+				return
+			}
+		}
+	}
+
+	p.setOrigin(w, origin)
+	w.Header().Set("Access-Control-Allow-Methods", p.allowMethod)
+	if p.anyHeader {
+		// "*" is not a wildcard with credentials , echo the requested headers
+		w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+	} else if p.allowHeader != "" {
+		w.Header().Set("Access-Control-Allow-Headers", p.allowHeader)
+	}
+	if p.maxAge != "" {
+		w.Header().Set("Access-Control-Max-Age", p.maxAge)
+	}
+}
+
+// isPreflight reports a CORS preflight (a plain OPTIONS request is routed like any method)
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// corsFor returns the policy of a route (the global one without override).
+// Routes without the cors middleware are same origin only.
+func (st *gatewayState) corsFor(route *models.RouteConfig) *corsPolicy {
+	if route == nil {
+		return st.cors
+	}
+	if !slices.ContainsFunc(st.pipelines[route], func(m *Middleware) bool { return m.Name == "cors" }) {
+		return nil
+	}
+	if p, ok := st.routeCORS[route]; ok {
+		return p
+	}
+	return st.cors
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+)
+
+func TestCORSOrigins(t *testing.T) {
+	p, err := newCORSPolicy(models.CORSConfig{AllowedOrigins: []string{"http://localhost:8080", "https://*.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"http://localhost:8080", true},
+		{"http://localhost:3000", false},
+		{"https://app.example.com", true},
+		{"https://a.b.example.com", true},
+		{"https://example.com", false},
+		{"https://evil.com/.example.com", false},
+		{"http://app.example.com", false},
+	}
+	for _, tt := range tests {
+		if got := p.allowOrigin(tt.origin); got != tt.allowed {
+			t.Errorf("allowOrigin(%s) = %v, want %v", tt.origin, got, tt.allowed)
+		}
+	}
+
+	if _, err := newCORSPolicy(models.CORSConfig{AllowedOrigins: []string{"https://*.*.com"}}); err == nil {
+		t.Error("two wildcards accepted")
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	credentials := true
+	global := models.CORSConfig{
+		AllowedOrigins:   []string{"https://*.example.com"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		MaxAge:           10 * time.Minute,
+		AllowCredentials: &credentials,
+	}
+	p, err := newCORSPolicy(global)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preflight := func(p *corsPolicy, method, headers string) http.Header {
+		r := httptest.NewRequest("OPTIONS", "/api/v1/feed", nil)
+		r.Header.Set("Origin", "https://app.example.com")
+		r.Header.Set("Access-Control-Request-Method", method)
+		r.Header.Set("Access-Control-Request-Headers", headers)
+		w := httptest.NewRecorder()
+		p.preflight(w, r)
+		if w.Code != http.StatusNoContent {
+			t.Errorf("status = %d, want 204", w.Code)
+		}
+		return w.Header()
+	}
+
+	h := preflight(p, "POST", "content-type, authorization")
+	// the origin is echoed with credentials
+	if got := h.Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Allow-Origin = %q", got)
+	}
+	if h.Get("Access-Control-Allow-Credentials") != "true" || h.Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("headers = %v", h)
+	}
+	if h := preflight(p, "DELETE", ""); h.Get("Access-Control-Allow-Origin") != "" {
+		t.Error("DELETE allowed")
+	}
+	if h := preflight(p, "GET", "x-custom"); h.Get("Access-Control-Allow-Origin") != "" {
+		t.Error("x-custom header allowed")
+	}
+
+	// "*" with credentials would let any site read the user responses
+	if _, err := newCORSPolicy(mergeCORS(global, &models.CORSConfig{AllowedOrigins: []string{"*"}})); err == nil {
+		t.Error("\"*\" accepted with credentials")
+	}
+	noCredentials := false
+	anyPolicy, err := newCORSPolicy(models.CORSConfig{AllowedOrigins: []string{"*"}, AllowedMethods: []string{"GET"}, AllowCredentials: &noCredentials})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := preflight(anyPolicy, "GET", ""); h.Get("Access-Control-Allow-Origin") != "*" || h.Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("\"*\" headers = %v", h)
+	}
+
+	// the route override only replaces its fields
+	routePolicy, err := newCORSPolicy(mergeCORS(global, &models.CORSConfig{AllowedMethods: []string{"DELETE"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := preflight(routePolicy, "DELETE", "authorization"); h.Get("Access-Control-Allow-Methods") != "DELETE" {
+		t.Errorf("route override headers = %v", h)
+	}
+}
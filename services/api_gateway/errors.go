@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// errorDomain is the ErrorInfo domain of the errors made by the gateway itself
+const errorDomain = "api-gateway"
+
+// httpStatusFromCode maps gRPC codes to HTTP status codes
+// (same mapping as https://github.com/googleapis/googleapis/blob/master/google/rpc/code.proto)
+func httpStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499 // client closed request
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	// Unknown , Internal , DataLoss
+	return http.StatusInternalServerError
+}
+
+// publicMessages replaces the backend messages of the codes that may carry internal details
+var publicMessages = map[codes.Code]string{
+	codes.Unknown:          "Internal error",
+	codes.Internal:         "Internal error",
+	codes.DataLoss:         "Internal error",
+	codes.Unavailable:      "Service not available",
+	codes.DeadlineExceeded: "Request timed out",
+	codes.Canceled:         "Request canceled",
+	codes.Unimplemented:    "Not implemented",
+}
+
+// grpcError is an error of the gateway itself that is sent to the client as a google.rpc.Status
+func grpcError(code codes.Code, reason, message string, details ...protoadapt.MessageV1) *status.Status {
+	st := status.New(code, message)
+	details = append([]protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: reason, Domain: errorDomain}}, details...)
+	withDetails, err := st.WithDetails(details...)
+	if err != nil {
+		return st
+	}
+	return withDetails
+}
+
+// retryInfo builds a RetryInfo detail with a delay in seconds
+func retryInfo(seconds int) *errdetails.RetryInfo {
+	return &errdetails.RetryInfo{RetryDelay: durationpb.New(time.Duration(seconds) * time.Second)}
+}
+
+// badRequest builds a BadRequest detail with one field violation
+func badRequest(field, description string) *errdetails.BadRequest {
+	return &errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: field, Description: description}},
+	}
+}
+
+// writeError writes a gateway error as a JSON google.rpc.Status
+func writeError(w http.ResponseWriter, code codes.Code, reason, message string, details ...protoadapt.MessageV1) {
+	writeStatus(w, grpcError(code, reason, message, details...))
+}
+
+// writeGRPCError writes an error returned by a backend.
+// Only the details meant for clients are kept and internal messages are replaced.
+func writeGRPCError(w http.ResponseWriter, err error) {
+	st, ok := status.FromError(err)
+	if !ok {
+		// not a gRPC status (ex: marshaling errors) , never send it as is
+		st = status.New(codes.Internal, "")
+	}
+	writeStatus(w, sanitizeStatus(st))
+}
+
+// sanitizeStatus drops internal messages and any detail that is not safe to expose (ex: DebugInfo)
+func sanitizeStatus(st *status.Status) *status.Status {
+	message := st.Message()
+	if public, ok := publicMessages[st.Code()]; ok {
+		message = public
+	}
+
+	var details []protoadapt.MessageV1
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo, *errdetails.BadRequest, *errdetails.RetryInfo,
+			*errdetails.QuotaFailure, *errdetails.PreconditionFailure, *errdetails.ResourceInfo,
+			*errdetails.LocalizedMessage, *errdetails.Help:
+			details = append(details, d.(protoadapt.MessageV1))
+		}
+	}
+
+	clean := status.New(st.Code(), message)
+	if len(details) == 0 {
+		details = append(details, &errdetails.ErrorInfo{Reason: st.Code().String(), Domain: errorDomain})
+	}
+	withDetails, err := clean.WithDetails(details...)
+	if err != nil {
+		return clean
+	}
+	return withDetails
+}
+
+func writeStatus(w http.ResponseWriter, st *status.Status) {
+	writeStatusCode(w, httpStatusFromCode(st.Code()), st)
+}
+
+// writeStatusCode is writeStatus for the few HTTP codes that have no gRPC code (ex: 405)
+func writeStatusCode(w http.ResponseWriter, httpStatus int, st *status.Status) {
+	// RetryInfo is also sent as the standard header
+	for _, detail := range st.Details() {
+		if ri, ok := detail.(*errdetails.RetryInfo); ok && ri.GetRetryDelay() != nil {
+			seconds := int(ri.GetRetryDelay().AsDuration().Round(time.Second) / time.Second)
+			w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+		}
+	}
+
+	body := statusJSON(st)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(httpStatus)
+	w.Write(body)
+}
+
+// statusFromRequestError maps the transcoding errors to InvalidArgument with the bad field
+func statusFromRequestError(err error) *status.Status {
+	var fe *fieldError
+	switch {
+	case errors.As(err, &fe):
+		return grpcError(codes.InvalidArgument, "INVALID_FIELD", "Invalid request", badRequest(fe.field, fe.err.Error()))
+	case errors.Is(err, errInvalidBody):
+		return grpcError(codes.InvalidArgument, "INVALID_BODY", "Invalid request body")
+	}
+	// ex: the route method is not loaded anymore
+	slog.Error("failed to build request", "error", err)
+	return grpcError(codes.Internal, "INTERNAL", "Internal error")
+}
+
+func statusJSON(st *status.Status) []byte {
+	body, err := protojson.Marshal(st.Proto())
+	if err != nil {
+		slog.Error("failed to marshal error status", "error", err)
+		return []byte(`{"code":13,"message":"Internal error"}`)
+	}
+	return body
+}
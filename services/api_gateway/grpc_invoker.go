@@ -2,18 +2,21 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
 	"strings"
 
 	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
 	"google.golang.org/genproto/googleapis/api/annotations"
 	"google.golang.org/grpc"
-	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protodesc"
 	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/types/descriptorpb"
 	"google.golang.org/protobuf/types/dynamicpb"
 )
@@ -36,6 +39,8 @@ type MethodDescriptor struct {
 	inputDescriptor  protoreflect.MessageDescriptor
 	outputDescriptor protoreflect.MessageDescriptor
 	fullMethodName   string
+	serverStreaming  bool
+	clientStreaming  bool
 }
 
 func NewGRPCInvoker(routeOptions map[string]*models.RouteOption) *GRPCInvoker {
@@ -59,6 +64,14 @@ func (g *GRPCInvoker) LoadProtoset(protosetPath, serviceName string) error {
 		return fmt.Errorf("failed to unmarshal protoset: %w", err)
 	}
 
+	g.LoadFileDescriptorSet(fds, serviceName)
+	log.Printf("Loaded protoset: %s", protosetPath)
+	return nil
+}
+
+// LoadFileDescriptorSet registers the services and HTTP annotations of a descriptor set
+// (from a protoset file or from server reflection). Files must be ordered by dependencies.
+func (g *GRPCInvoker) LoadFileDescriptorSet(fds *descriptorpb.FileDescriptorSet, serviceName string) {
 	resolver := &fileResolver{filesByPath: make(map[string]protoreflect.FileDescriptor)}
 
 	for _, fdProto := range fds.File {
@@ -78,9 +91,6 @@ func (g *GRPCInvoker) LoadProtoset(protosetPath, serviceName string) error {
 			g.registerHttpRoutes(fdProto, svc, serviceName)
 		}
 	}
-
-	log.Printf("Loaded protoset: %s", protosetPath)
-	return nil
 }
 
 // registerService registers gRPC service methods for invocation
@@ -101,6 +111,8 @@ func (g *GRPCInvoker) registerService(svc protoreflect.ServiceDescriptor) {
 			inputDescriptor:  method.Input(),
 			outputDescriptor: method.Output(),
 			fullMethodName:   fmt.Sprintf("/%s/%s", grpcServiceName, methodName),
+			serverStreaming:  method.IsStreamingServer(),
+			clientStreaming:  method.IsStreamingClient(),
 		}
 		log.Printf("Registered gRPC method: /%s/%s", grpcServiceName, methodName)
 	}
@@ -120,47 +132,70 @@ func (g *GRPCInvoker) registerHttpRoutes(fdProto *descriptorpb.FileDescriptorPro
 
 		for _, methodProto := range svcProto.GetMethod() {
 			opts := methodProto.GetOptions()
-			if opts == nil {
-				continue
-			}
 
 			// Use typed API to extract google.api.http annotation
 			httpRule := g.getHttpRule(opts)
 			if httpRule == nil {
-				continue
-			}
-
-			httpMethod, httpPath := extractMethodAndPath(httpRule)
-			if httpMethod == "" || httpPath == "" {
-				continue
+				// streaming clients have a WebSocket even without annotations
+				if md, err := g.method(grpcServiceName, methodProto.GetName()); err == nil && md.clientStreaming {
+					httpRule = &annotations.HttpRule{
+						Pattern: &annotations.HttpRule_Get{Get: fmt.Sprintf("/ws/%s/%s", grpcServiceName, methodProto.GetName())},
+					}
+				} else {
+					continue
+				}
 			}
 
-			route := &models.RouteConfig{
-				Path:           httpPath,
-				Method:         httpMethod,
-				Body:           httpRule.Body,
-				GRPCService:    grpcServiceName,
-				GRPCMethod:     methodProto.GetName(),
-				BackendService: serviceName, // maps to k8s_services config key
+			// the main binding and its additional_bindings share the same gRPC method
+			bindings := append([]*annotations.HttpRule{httpRule}, httpRule.GetAdditionalBindings()...)
+			for _, binding := range bindings {
+				g.registerBinding(binding, grpcServiceName, methodProto.GetName(), serviceName)
 			}
+		}
+	}
+}
 
-			if g.httpRoutes[httpMethod] == nil {
-				g.httpRoutes[httpMethod] = make(map[string]*models.RouteConfig)
-			}
-			if val, ok := g.routeOptions[route.Path]; ok {
-				route.RateLimitEnabled = val.RateLimitEnabled
-				route.RequireAuth = val.RequireAuth
-			}
-			g.httpRoutes[httpMethod][httpPath] = route
+func (g *GRPCInvoker) registerBinding(rule *annotations.HttpRule, grpcServiceName, grpcMethod, serviceName string) {
+	httpMethod, httpPath := extractMethodAndPath(rule)
+	if httpMethod == "" || httpPath == "" {
+		return
+	}
 
-			log.Printf("Registered HTTP route: %s %s -> %s/%s", httpMethod, httpPath, grpcServiceName, methodProto.GetName())
+	route := &models.RouteConfig{
+		Path:           httpPath,
+		Method:         httpMethod,
+		Body:           rule.GetBody(),
+		ResponseBody:   rule.GetResponseBody(),
+		GRPCService:    grpcServiceName,
+		GRPCMethod:     grpcMethod,
+		BackendService: serviceName, // maps to k8s_services config key
+	}
+	if md, err := g.method(grpcServiceName, grpcMethod); err == nil {
+		route.ServerStreaming = md.serverStreaming && !md.clientStreaming
+		if md.clientStreaming {
+			// the WebSocket handshake is always a GET , the frames are the request messages
+			route.WebSocket = true
+			route.Method = "GET"
+			route.Body = "*"
+			httpMethod = "GET"
 		}
 	}
+
+	if g.httpRoutes[httpMethod] == nil {
+		g.httpRoutes[httpMethod] = make(map[string]*models.RouteConfig)
+	}
+	if val, ok := g.routeOptions[route.Path]; ok {
+		route.RateLimitEnabled = val.RateLimitEnabled
+		route.RequireAuth = val.RequireAuth
+	}
+	g.httpRoutes[httpMethod][httpPath] = route
+
+	log.Printf("Registered HTTP route: %s %s -> %s/%s", httpMethod, httpPath, grpcServiceName, grpcMethod)
 }
 
 // getHttpRule extracts the google.api.http annotation using the typed extension API
 func (g *GRPCInvoker) getHttpRule(opts *descriptorpb.MethodOptions) *annotations.HttpRule {
-	if !proto.HasExtension(opts, annotations.E_Http) {
+	if opts == nil || !proto.HasExtension(opts, annotations.E_Http) {
 		return nil
 	}
 
@@ -184,6 +219,8 @@ func extractMethodAndPath(rule *annotations.HttpRule) (method, path string) {
 		return "PUT", pattern.Put
 	case *annotations.HttpRule_Patch:
 		return "PATCH", pattern.Patch
+	case *annotations.HttpRule_Custom:
+		return strings.ToUpper(pattern.Custom.GetKind()), pattern.Custom.GetPath()
 	}
 	return "", ""
 }
@@ -193,8 +230,8 @@ func (g *GRPCInvoker) GetHttpRoutes() map[string]map[string]*models.RouteConfig
 	return g.httpRoutes
 }
 
-// Invoke calls a gRPC method dynamically
-func (g *GRPCInvoker) Invoke(ctx context.Context, conn *grpc.ClientConn, serviceName, methodName string, requestJSON []byte) ([]byte, error) {
+// method returns the descriptor of a loaded gRPC method
+func (g *GRPCInvoker) method(serviceName, methodName string) (*MethodDescriptor, error) {
 	sd, exists := g.serviceDescriptors[serviceName]
 	if !exists {
 		return nil, fmt.Errorf("service %s not found", serviceName)
@@ -204,25 +241,27 @@ func (g *GRPCInvoker) Invoke(ctx context.Context, conn *grpc.ClientConn, service
 	if !exists {
 		return nil, fmt.Errorf("method %s not found in service %s", methodName, serviceName)
 	}
+	return md, nil
+}
 
-	// Create request message and unmarshal JSON
-	reqMsg := dynamicpb.NewMessage(md.inputDescriptor)
-	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
-	if err := unmarshaler.Unmarshal(requestJSON, reqMsg); err != nil {
-		log.Printf("Failed to unmarshal request for %s: %v", md.fullMethodName, err)
-		return nil, fmt.Errorf("failed to unmarshal request: %w", err)
+// Invoke calls the gRPC method of the route dynamically with a transcoded request (see BuildRequest)
+func (g *GRPCInvoker) Invoke(ctx context.Context, conn *grpc.ClientConn, route *models.RouteConfig, reqMsg proto.Message) ([]byte, error) {
+	md, err := g.method(route.GRPCService, route.GRPCMethod)
+	if err != nil {
+		return nil, err
 	}
 
 	// Create response message and invoke
+	// (the trace context of ctx is sent as metadata by the otelgrpc handler of the conn)
 	respMsg := dynamicpb.NewMessage(md.outputDescriptor)
 	if err := conn.Invoke(ctx, md.fullMethodName, reqMsg, respMsg); err != nil {
-		log.Printf("gRPC call failed for %s: %v", md.fullMethodName, err)
-		return nil, fmt.Errorf("failed to invoke gRPC method: %w", err)
+		slog.DebugContext(ctx, "gRPC call failed", "method", md.fullMethodName, "error", err)
+		// the backend status as is , it is sanitized before it reaches the client
+		return nil, err
 	}
 
-	// Marshal response to JSON
-	marshaler := protojson.MarshalOptions{UseProtoNames: true}
-	responseJSON, err := marshaler.Marshal(respMsg)
+	// Marshal response (or its response_body field) to JSON
+	responseJSON, err := marshalResponse(respMsg, route.ResponseBody)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal response: %w", err)
 	}
@@ -230,24 +269,93 @@ func (g *GRPCInvoker) Invoke(ctx context.Context, conn *grpc.ClientConn, service
 	return responseJSON, nil
 }
 
-// MatchPath checks if a request path matches a route pattern with path parameters
-func MatchPath(pattern, path string) bool {
-	patternParts := strings.Split(strings.Trim(pattern, "/"), "/")
-	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+// errClientGone is returned by InvokeStream when the HTTP client can`t be written anymore
+var errClientGone = errors.New("client write failed")
+
+// InvokeStream calls a server-streaming gRPC method and passes every response (as JSON) to send.
+// send blocks while the client is slow , so the gRPC flow control pushes back to the backend.
+// Canceling ctx (client disconnect , deadline) cancels the gRPC stream.
+func (g *GRPCInvoker) InvokeStream(ctx context.Context, conn *grpc.ClientConn, route *models.RouteConfig, reqMsg proto.Message, send func([]byte) error) error {
+	md, err := g.method(route.GRPCService, route.GRPCMethod)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	if len(patternParts) != len(pathParts) {
-		return false
+	// the errors are the backend statuses as is , they are sanitized before they reach the client
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, md.fullMethodName)
+	if err != nil {
+		return err
+	}
+	// io.EOF means the backend ended the stream , its status comes with RecvMsg
+	if err := stream.SendMsg(reqMsg); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
 	}
 
-	for i, pp := range patternParts {
-		if strings.HasPrefix(pp, "{") && strings.HasSuffix(pp, "}") {
-			continue // Path parameter matches anything
+	for {
+		respMsg := dynamicpb.NewMessage(md.outputDescriptor)
+		if err := stream.RecvMsg(respMsg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			slog.DebugContext(ctx, "gRPC stream failed", "method", md.fullMethodName, "error", err)
+			return err
 		}
-		if pp != pathParts[i] {
-			return false
+		data, err := marshalResponse(respMsg, route.ResponseBody)
+		if err != nil {
+			return fmt.Errorf("failed to marshal response: %w", err)
+		}
+		if err := send(data); err != nil {
+			// the client is gone , cancel stops the backend stream
+			return fmt.Errorf("%w: %v", errClientGone, err)
 		}
 	}
-	return true
+}
+
+// ClientStream is a client/bidi-streaming call of a route with JSON responses
+type ClientStream struct {
+	stream grpc.ClientStream
+	route  *models.RouteConfig
+	output protoreflect.MessageDescriptor
+}
+
+// NewClientStream opens a client/bidi-streaming call , canceling ctx ends it
+func (g *GRPCInvoker) NewClientStream(ctx context.Context, conn *grpc.ClientConn, route *models.RouteConfig) (*ClientStream, error) {
+	md, err := g.method(route.GRPCService, route.GRPCMethod)
+	if err != nil {
+		return nil, err
+	}
+	desc := &grpc.StreamDesc{ClientStreams: true, ServerStreams: md.serverStreaming}
+	// the errors are the backend statuses as is , they are sanitized before they reach the client
+	stream, err := conn.NewStream(ctx, desc, md.fullMethodName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stream: %w", err)
+	}
+	return &ClientStream{stream: stream, route: route, output: md.outputDescriptor}, nil
+}
+
+// Send sends one request message (built with BuildRequest)
+func (cs *ClientStream) Send(reqMsg proto.Message) error {
+	return cs.stream.SendMsg(reqMsg)
+}
+
+// CloseSend tells the backend there are no more requests
+func (cs *ClientStream) CloseSend() error {
+	return cs.stream.CloseSend()
+}
+
+// Recv returns the next response as JSON , io.EOF when the backend ends the stream
+func (cs *ClientStream) Recv() ([]byte, error) {
+	respMsg := dynamicpb.NewMessage(cs.output)
+	if err := cs.stream.RecvMsg(respMsg); err != nil {
+		return nil, err
+	}
+	return marshalResponse(respMsg, cs.route.ResponseBody)
 }
 
 // fileResolver implements protodesc.Resolver for building file descriptors
@@ -259,6 +367,10 @@ func (r *fileResolver) FindFileByPath(path string) (protoreflect.FileDescriptor,
 	if fd, ok := r.filesByPath[path]; ok {
 		return fd, nil
 	}
+	// well known imports (ex: google/api/annotations.proto) are linked in the gateway
+	if fd, err := protoregistry.GlobalFiles.FindFileByPath(path); err == nil {
+		return fd, nil
+	}
 	return nil, fmt.Errorf("file not found: %s", path)
 }
 
@@ -1,76 +1,57 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
-	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/descriptorpb"
 
 	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/logging"
 )
 
 type Handler struct {
-	config       *models.AppConfig
 	serviceConns *ServiceConnections // direct gRPC conns to K8s services
-	grpcInvoker  *GRPCInvoker
 	rateLimiter  *RateLimiter
 	redis        *redis.Client
-	routeMap     map[string]map[string]*models.RouteConfig // method -> path -> config
+	keys         *KeyManager                  // JWT signing keys of the user service
+	state        atomic.Pointer[gatewayState] // swapped on config reload
+	reloadMu     sync.Mutex                   // one state build at a time
+	retryBudgets sync.Map                     // backend -> *retryBudget , kept across reloads
+	inflight     singleflight.Group           // calls of the shareable routes
 	wg           *sync.WaitGroup
 }
 
-// TODO: handle that dynamically
-var multiValueParams = map[string]bool{
-	"PostId": true,
-	"UserId": true,
-}
-
-func NewHandler(config *models.AppConfig, serviceConns *ServiceConnections, grpcInvoker *GRPCInvoker, rateLimiter *RateLimiter, redis *redis.Client) *Handler {
+func NewHandler(config *models.AppConfig, serviceConns *ServiceConnections, rateLimiter *RateLimiter, redis *redis.Client) *Handler {
 	h := &Handler{
-		config:       config,
 		serviceConns: serviceConns,
-		grpcInvoker:  grpcInvoker,
 		rateLimiter:  rateLimiter,
 		redis:        redis,
-		routeMap:     make(map[string]map[string]*models.RouteConfig),
 		wg:           &sync.WaitGroup{},
 	}
-	var err error
-
-	// log.Println(config.Server.PublickeyAddr)
-	config.PublicKey, err = GetPublicKey(config.Server.PublickeyAddr)
+	// the keys are loaded in the background (see KeyManager.Run) , startup does not need the user service
+	h.keys = NewKeyManager(config.Server.JWKSURL, config.Server.PublickeyAddr, config.Server.KeysRefresh)
 
+	var descriptors map[string]*descriptorpb.FileDescriptorSet
+	if config.Descriptors.Source == SourceReflection {
+		descriptors = fetchAllDescriptors(serviceConns, config.K8sServices, nil)
+	}
+	// missing protosets are not fatal at startup, only on reload
+	st, err := buildGatewayState(config, serviceConns, descriptors, false)
 	if err != nil {
-		log.Println("Error in Loading the public key")
+		slog.Error("error in building gateway routes", "error", err)
 		return nil
 	}
-
-	// Build route map from google.api.http annotations parsed by grpcInvoker
-	httpRoutes := grpcInvoker.GetHttpRoutes()
-	for method, routes := range httpRoutes {
-		if h.routeMap[method] == nil {
-			h.routeMap[method] = make(map[string]*models.RouteConfig)
-		}
-		for path, route := range routes {
-			// Apply route options from config if available
-			if config.RouteOptions != nil {
-				if opts, ok := config.RouteOptions[path]; ok {
-					route.RequireAuth = opts.RequireAuth
-					route.RateLimitEnabled = opts.RateLimitEnabled
-				}
-			}
-			h.routeMap[method][path] = route
-			log.Printf("Registered route: %s %s -> %s/%s (auth=%v, rate_limit=%v)",
-				method, path, route.GRPCService, route.GRPCMethod, route.RequireAuth, route.RateLimitEnabled)
-		}
-	}
+	h.state.Store(st)
 
 	return h
 }
@@ -78,155 +59,130 @@ func NewHandler(config *models.AppConfig, serviceConns *ServiceConnections, grpc
 // GenericHandler handles all HTTP requests and routes them to the required gRPC services
 func (h *Handler) GenericHandler(w http.ResponseWriter, r *http.Request) {
 
-	log.Printf("%s is requested\n", r.URL.Path)
-
-	// CORS
-	w.Header().Set("Access-Control-Allow-Origin", "localhost:8080") // mock url for now
-	w.Header().Set("Access-Control-Allow-Credentials", "true")
-
-	// preflight
-	if r.Method == http.MethodOptions {
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type , Authorization , RefreshToken")
-		w.WriteHeader(http.StatusNoContent)
+	// metrics are labeled by the route template , not the path
+	start := time.Now()
+	routeLabel := unmatchedRoute
+	recorder := &statusRecorder{ResponseWriter: w}
+	w = recorder
+	r, span := startHTTPSpan(r)
+	// the request id is logged by the gateway and the backends
+	reqID := newRequestID(r.Header.Get(requestIDHeader))
+	r = r.WithContext(logging.WithRequestID(r.Context(), reqID))
+	w.Header().Set(requestIDHeader, reqID)
+	// In-flight requests keep the state they started with during a reload
+	st := h.state.Load()
+	// one client ip for the rate limits , the logs and the backends
+	r = r.WithContext(withClientIP(r.Context(), st.clientIPs.resolve(r)))
+	httpInFlight.Inc()
+	defer func() {
+		httpInFlight.Dec()
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		observeRequest(routeLabel, r.Method, status, time.Since(start))
+		endHTTPSpan(span, routeLabel, status)
+		// the query is not logged , it can carry user data
+		slog.InfoContext(r.Context(), "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"route", routeLabel,
+			"status", status,
+			"duration", time.Since(start),
+		)
+	}()
+
+	// CORS preflight , answered with the policy of the route it asks for
+	if isPreflight(r) {
+		route, _, _ := st.router.Match(r.Header.Get("Access-Control-Request-Method"), r.URL.EscapedPath())
+		st.corsFor(route).preflight(w, r)
 		return
 	}
 
 	// Find matching route
-	route := h.findRoute(r.Method, r.URL.Path)
+	route, pathParams, pathFound := st.router.Match(r.Method, r.URL.EscapedPath())
 	if route == nil {
-		http.Error(w, "Route not found", http.StatusNotFound)
+		if pathFound {
+			// no gRPC code maps to 405
+			st.cors.apply(w, r)
+			writeStatusCode(w, http.StatusMethodNotAllowed, grpcError(codes.Unimplemented, "METHOD_NOT_ALLOWED", "Method not allowed"))
+			return
+		}
+		// the browser must be able to read the error
+		st.cors.apply(w, r)
+		writeError(w, codes.NotFound, "ROUTE_NOT_FOUND", "Route not found")
 		return
 	}
 
-	// Apply rate limiting if enabled
-	if route.RateLimitEnabled {
-		rateLimitInfo, err := h.rateLimiter.AllowIP(r)
-		if err != nil {
-			log.Printf("Rate limiter error: %v", err)
-			// Fail open
-		} else {
-			// Add rate limiting headers
-			if rateLimitInfo == nil {
-				return
-			}
+	routeLabel = route.Path
+	span.SetName(r.Method + " " + route.Path)
 
-			w.Header().Set("X-Ratelimit-Remaining", fmt.Sprintf("%d", rateLimitInfo.Remaining))
-			w.Header().Set("X-Ratelimit-Limit", fmt.Sprintf("%d", rateLimitInfo.Limit))
-
-			if !rateLimitInfo.Allowed {
-				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
-				if rateLimitInfo.RetryAfterSeconds > 0 {
-					w.Header().Set("X-Ratelimit-Retry-After", fmt.Sprintf("%d", rateLimitInfo.RetryAfterSeconds))
-				}
-				return
-			}
+	// Run the route pipeline (cors , rate limits , csrf , auth ...)
+	rc := &requestContext{w: w, r: r, st: st, route: route, pathParams: pathParams, tokens: extractTokens(r)}
+	pipeline := st.pipelines[route]
+	for _, m := range pipeline {
+		if m.Before != nil && !m.Before(h, rc) {
+			return // the middleware already wrote the response
 		}
 	}
+	tokens, userID := rc.tokens, rc.userID
+	// the allowlisted identity and context of the request go to the backend as gRPC metadata
+	r = r.WithContext(metadata.NewOutgoingContext(r.Context(), identityMetadata(st.config.Identity, rc)))
 
-	// Check authentication if required
-	var userID string
-	if route.RequireAuth {
-		var ok bool
-		userID, ok = h.checkAuth(w, r)
-		if !ok {
-			return // Auth middleware already wrote error
-		}
-		allowed, err := h.rateLimiter.AllowRules(r)
+	if route.WebSocket {
+		conn, err := h.serviceConns.GetConn(route.BackendService)
 		if err != nil {
-			log.Printf("Rate limiter error: %v", err)
-			// Fail open
-		} else {
-
-			if !allowed.Allowed {
-				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
-				return
-			}
+			slog.ErrorContext(r.Context(), "no connection for backend", "backend", route.BackendService, "error", err)
+			writeError(w, codes.Unavailable, "BACKEND_UNAVAILABLE", "Service not available")
+			return
 		}
+		h.serveWebSocket(w, r, st, conn, route, pathParams, injectedFields(tokens, userID))
+		return
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		writeError(w, codes.InvalidArgument, "INVALID_BODY", "Failed to read request body")
 		return
 	}
 	defer r.Body.Close()
 
-	// Build Request body , add any params found
-	requestData, err := h.buildRequestData(r, route, body, userID)
+	// Transcode the HTTP request to the gRPC request (body , path vars , query params)
+	reqMsg, err := st.grpcInvoker.BuildRequest(route, body, pathParams, r.URL.Query(), injectedFields(tokens, userID))
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to build request: %v", err), http.StatusBadRequest)
+		writeStatus(w, statusFromRequestError(err))
 		return
 	}
 
 	conn, err := h.serviceConns.GetConn(route.BackendService)
 	if err != nil {
-		log.Printf("No connection for backend %s: %v", route.BackendService, err)
-		http.Error(w, "Service not available", http.StatusServiceUnavailable)
+		slog.ErrorContext(r.Context(), "no connection for backend", "backend", route.BackendService, "error", err)
+		writeError(w, codes.Unavailable, "BACKEND_UNAVAILABLE", "Service not available")
+		return
+	}
+
+	if route.ServerStreaming {
+		h.serveStream(w, r, st, conn, route, reqMsg)
 		return
 	}
 
 	// Invoke gRPC method dynamically
 	// h.wg.Add(1)
-	responseJSON, err := h.grpcInvoker.Invoke(
-		r.Context(),
-		conn,
-		route.GRPCService,
-		route.GRPCMethod,
-		requestData,
-	)
+	responseJSON, err := h.coalesce(r.Context(), st, conn, route, reqMsg)
 
 	// Request To service End
 	// h.wg.Done()
 	if err != nil {
-		log.Printf("gRPC invocation error: %v", err)
-		http.Error(w, fmt.Sprintf("Service error: %v", err), http.StatusInternalServerError)
+		slog.WarnContext(r.Context(), "gRPC invocation error", "method", route.GRPCMethod, "error", err)
+		writeGRPCError(w, err)
 		return
 	}
 
-	// TODO:
-	// Try to refactor and find more modular way to do that
-	if strings.HasSuffix(route.Path, "login") || strings.HasSuffix(route.Path, "refresh") {
-		var token models.Tokens
-
-		if err := json.Unmarshal(responseJSON, &token); err != nil {
-			log.Println("tokens unmarshal failed")
-			http.Error(w, fmt.Sprintf("Decoding Error , %v", err), http.StatusInternalServerError)
-		}
-
-		access_token := &http.Cookie{
-			Name:     "accessToken",
-			Value:    token.Access,
-			Path:     "/",
-			HttpOnly: true,
-			SameSite: http.SameSiteNoneMode,
-			//  Secure:   true,  required in production for https
-		}
-
-		refresh_token := &http.Cookie{
-			Name:  "refreshToken",
-			Value: token.Refresh,
-			// TODO:
-			// I can send this token only into some path like api/v1/refresh
-			// Is there a way to send to only two paths instead of duplicate the token or not ?
-			Path:     "/",
-			HttpOnly: true,
-			SameSite: http.SameSiteNoneMode,
-			//  Secure:   true,  required in production for https
-		}
-		http.SetCookie(w, access_token)
-		http.SetCookie(w, refresh_token)
-	}
-
-	if strings.HasSuffix(route.Path, "logout") {
-		accessToken, ok := h.extractTokens(r)["accessToken"].(string)
-		if !ok || accessToken == "" {
-			log.Println("Invalid Access Token")
-			http.Error(w, fmt.Sprintf("Invalid Access Token: %v", err), http.StatusUnauthorized)
+	// Response hooks (ex: set the token cookies on login , revoke the token on logout)
+	for _, m := range pipeline {
+		if m.After != nil && !m.After(h, rc, responseJSON) {
+			return
 		}
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-		h.redis.Eval(ctx, h.config.Redis.AddScript, []string{accessToken}, []interface{}{5 * 60 * time.Second})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -234,131 +190,45 @@ func (h *Handler) GenericHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(responseJSON)
 }
 
-// findRoute finds the matching route configuration
-func (h *Handler) findRoute(method, path string) *models.RouteConfig {
-	routes, exists := h.routeMap[method]
-	if !exists {
-		return nil
-	}
-
-	// First try exact match
-	if route, exists := routes[path]; exists {
-		return route
-	}
-
-	// Try pattern matching for paths with parameters
-	for routePath, route := range routes {
-		if MatchPath(routePath, path) {
-			return route
-		}
-	}
-
-	return nil
-}
-
-// buildRequestData builds the gRPC request from HTTP request
-func (h *Handler) buildRequestData(r *http.Request, route *models.RouteConfig, body []byte, userID string) ([]byte, error) {
-	var data map[string]interface{}
-
-	if len(body) > 0 {
-		if err := json.Unmarshal(body, &data); err != nil {
-			data = make(map[string]interface{})
-		}
-	} else {
-		data = make(map[string]interface{})
-	}
-	// extract header/Tokens
-	tokenParams := h.extractTokens(r)
-
-	for key, value := range tokenParams {
-		data[key] = value
-	}
-
-	if userID != "" {
-		data["UserId"] = userID
-	}
-	// For patterns like /api/v1/posts/{postId}, this extracts "postId"
-	pathParams := h.extractPathParams(route.Path, r)
-	for key, value := range pathParams {
-		data[key] = value
-	}
-
-	// query parameters
-	for key, values := range r.URL.Query() {
-		// log.Println(key, "--->", values, "---->", multiValueParams[key])
-		if multiValueParams[key] {
-			data[key] = values
-		} else {
-			data[key] = values[0]
-		}
-	}
-	for key := range data {
-		log.Println(key, "   ", data[key])
-	}
-	return json.Marshal(data)
-}
-
-func (h *Handler) extractTokens(r *http.Request) map[string]interface{} {
-
-	tokens := make(map[string]interface{})
-	var token string
-	authHeader := r.Header.Get("Authorization")
-	if authHeader != "" {
-		token = strings.TrimPrefix(authHeader, "Bearer ")
-	}
-
-	tokens["accessToken"] = token
-
-	token = r.Header.Get("refreshToken")
-	// log.Println("RefreshToken", token)
-	tokens["refreshToken"] = token
-	return tokens
-}
-
-func (h *Handler) extractPathParams(pattern string, r *http.Request) map[string]interface{} {
-	params := make(map[string]interface{})
-
-	patternParts := strings.Split(pattern, "/")
+// injectedUserField is the user of the request for the backends that don`t read the x-user-id metadata
+const injectedUserField = "UserId"
 
-	for _, part := range patternParts {
-		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
-			paramName := strings.TrimSuffix(strings.TrimPrefix(part, "{"), "}")
-			if value := r.PathValue(paramName); value != "" {
-				log.Println(paramName, "  ", value)
-				params[paramName] = value
-			}
-		}
+// injectedFields are the request fields set by the gateway (if the gRPC request has them)
+func injectedFields(tokens requestTokens, userID string) map[string]string {
+	return map[string]string{
+		injectedUserField: userID,
+		"accessToken":     tokens.access,
+		"refreshToken":    tokens.refresh,
 	}
-
-	return params
 }
 
-func (h *Handler) checkAuth(w http.ResponseWriter, r *http.Request) (string, bool) {
-	authToken, ok := h.extractTokens(r)["accessToken"].(string)
-	if !ok || authToken == "" {
-		log.Println("NO Authorization header found")
-		http.Error(w, "Authorization header required", http.StatusUnauthorized)
-		return "", false
+func (h *Handler) checkAuth(w http.ResponseWriter, authToken string) (jwt.MapClaims, bool) {
+	if authToken == "" {
+		slog.Debug("no authorization token")
+		tokenFailures.WithLabelValues("missing").Inc()
+		writeError(w, codes.Unauthenticated, "MISSING_TOKEN", "Authorization header required")
+		return nil, false
 	}
 	// Add nil check for redis
 	if h.redis == nil {
-		log.Println("Redis Connection is nil")
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return "", false
+		slog.Error("redis connection is nil")
+		writeError(w, codes.Internal, "INTERNAL", "Internal error")
+		return nil, false
 	}
 
-	userID, err := ValidateToken(authToken, h.config.PublicKey, h.redis, h.config.Redis.CheckScript)
+	config := h.state.Load().config
+	claims, err := ValidateToken(authToken, h.keys, h.redis, config.Redis.CheckScript)
 	if err != nil {
-		log.Printf("Token validation error: %v", err)
+		slog.Info("token validation failed", "error", err)
 		if err.Error() == "invalid" {
-			http.Error(w, "Invalid or expired token", http.StatusUnauthorized)
+			writeError(w, codes.Unauthenticated, "INVALID_TOKEN", "Invalid or expired token")
 		} else {
-			http.Error(w, "Internal Error", http.StatusInternalServerError)
+			writeError(w, codes.Internal, "INTERNAL", "Internal error")
 		}
-		return "", false
+		return nil, false
 	}
 
-	return userID, true
+	return claims, true
 }
 
 func (h *Handler) close() {
@@ -369,5 +239,5 @@ func (h *Handler) close() {
 
 // GetRouteMap returns the route map for server registration
 func (h *Handler) GetRouteMap() map[string]map[string]*models.RouteConfig {
-	return h.routeMap
+	return h.state.Load().router.Routes()
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"google.golang.org/grpc/metadata"
+)
+
+// identity.forward values , the request id is always forwarded (see logging.UnaryClientInterceptor)
+const (
+	ForwardUserID    = "user_id"
+	ForwardClientIP  = "client_ip"
+	ForwardUserAgent = "user_agent"
+)
+
+// forwardMetadata is the gRPC metadata key of each identity.forward value
+// (user-agent is set by the gRPC client itself)
+var forwardMetadata = map[string]string{
+	ForwardUserID:    "x-user-id",
+	ForwardClientIP:  "x-client-ip",
+	ForwardUserAgent: "x-user-agent",
+}
+
+// claimMetadataPrefix + the claim name is the key of a forwarded JWT claim
+const claimMetadataPrefix = "x-claim-"
+
+// identityMetadata is the request context of rc allowed by cfg.
+// Nothing comes from the client headers as is: the user and the claims are the ones of the verified token.
+func identityMetadata(cfg models.IdentityConfig, rc *requestContext) metadata.MD {
+	md := metadata.MD{}
+	for _, name := range cfg.Forward {
+		var value string
+		switch name {
+		case ForwardUserID:
+			value = rc.userID
+		case ForwardClientIP:
+			value = clientIP(rc.r)
+		case ForwardUserAgent:
+			value = rc.r.UserAgent()
+		}
+		if value != "" {
+			md.Set(forwardMetadata[name], value)
+		}
+	}
+	for _, claim := range cfg.Claims {
+		if value, ok := claimValue(rc, claim); ok {
+			md.Set(claimMetadataPrefix+strings.ToLower(claim), value)
+		}
+	}
+	return md
+}
+
+// claimValue is a string claim as is , the others in JSON (ex: a list of roles)
+func claimValue(rc *requestContext, claim string) (string, bool) {
+	raw, ok := rc.claims[claim]
+	if !ok || raw == nil {
+		return "", false
+	}
+	if s, ok := raw.(string); ok {
+		return s, s != ""
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
+
+// checkIdentityForward validates identity.forward.
+// user_id is required , the Go services answer Unauthenticated without x-user-id.
+func checkIdentityForward(forward []string) error {
+	for _, name := range forward {
+		if _, ok := forwardMetadata[name]; !ok {
+			return fmt.Errorf("identity.forward: unknown value %q", name)
+		}
+	}
+	if !slices.Contains(forward, ForwardUserID) {
+		return fmt.Errorf("identity.forward: %q is required by the backends", ForwardUserID)
+	}
+	return nil
+}
+
+// forwardsUser reports whether the outgoing call carries the user , its response is personal
+func forwardsUser(md metadata.MD) bool {
+	return len(md.Get(forwardMetadata[ForwardUserID])) > 0
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/logging"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestIdentityMetadata(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/v1/feed", nil)
+	r.RemoteAddr = "203.0.113.7:41234"
+	r.Header.Set("User-Agent", "test-agent")
+	r = r.WithContext(logging.WithRequestID(r.Context(), "req-1"))
+	rc := &requestContext{r: r, userID: "42", claims: jwt.MapClaims{"sub": "42", "role": "admin", "scopes": []any{"read", "write"}}}
+
+	cfg := models.IdentityConfig{
+		Forward: []string{ForwardUserID, ForwardClientIP, ForwardUserAgent},
+		Claims:  []string{"role", "scopes", "missing"},
+	}
+	md := identityMetadata(cfg, rc)
+	for key, want := range map[string]string{
+		"x-user-id":      "42",
+		"x-client-ip":    "203.0.113.7",
+		"x-user-agent":   "test-agent",
+		"x-claim-role":   "admin",
+		"x-claim-scopes": `["read","write"]`,
+	} {
+		if got := md.Get(key); len(got) != 1 || got[0] != want {
+			t.Errorf("%s = %v , want %q", key, got, want)
+		}
+	}
+	if got := md.Get("x-claim-missing"); len(got) != 0 {
+		t.Errorf("x-claim-missing = %v", got)
+	}
+	if !forwardsUser(md) {
+		t.Error("the user is forwarded")
+	}
+
+	// only the allowlist , the request id is sent by logging.UnaryClientInterceptor
+	md = identityMetadata(models.IdentityConfig{Forward: []string{ForwardUserAgent}}, rc)
+	if len(md) != 1 || forwardsUser(md) {
+		t.Errorf("metadata = %v , want the user agent only", md)
+	}
+}
+
+func TestCheckIdentityForward(t *testing.T) {
+	if err := checkIdentityForward([]string{ForwardUserID, ForwardClientIP}); err != nil {
+		t.Error(err)
+	}
+	if err := checkIdentityForward([]string{ForwardClientIP}); err == nil {
+		t.Error("the backends need user_id")
+	}
+	if err := checkIdentityForward([]string{ForwardUserID, "password"}); err == nil {
+		t.Error("unknown value accepted")
+	}
+}
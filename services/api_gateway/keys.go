@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minKeyRefresh limits the refreshes triggered by unknown kids (random kids must not flood the user service)
+const minKeyRefresh = 10 * time.Second
+
+var errUnknownKey = errors.New("unknown signing key")
+
+// KeyManager caches the JWT signing keys of the user service.
+// Keys come from a JWKS document and are selected by the token kid , so the user service
+// can rotate its keys without restarting the gateways.
+// Without a JWKS url the legacy PEM endpoint is used (one key without kid).
+type KeyManager struct {
+	jwksURL   string
+	pemURL    string
+	client    *http.Client
+	interval  time.Duration
+	refreshMu sync.Mutex // one fetch at a time
+	mu        sync.RWMutex
+	keys      map[string]*rsa.PublicKey // kid -> key
+	lastFetch time.Time                 // start of the last fetch , the failed ones are rate limited too
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+func NewKeyManager(jwksURL, pemURL string, interval time.Duration) *KeyManager {
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	return &KeyManager{
+		jwksURL:  jwksURL,
+		pemURL:   pemURL,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		interval: interval,
+		keys:     make(map[string]*rsa.PublicKey),
+	}
+}
+
+// Run refreshes the keys until ctx is done. The first fetch can fail (ex: the user service
+// is still starting) , requests get 401 until a refresh succeeds.
+func (km *KeyManager) Run(ctx context.Context) {
+	if err := km.Refresh(); err != nil {
+		log.Printf("Warning: Failed to load the signing keys: %v", err)
+	}
+	ticker := time.NewTicker(km.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := km.Refresh(); err != nil {
+				log.Printf("Warning: Failed to refresh the signing keys: %v", err)
+			}
+		}
+	}
+}
+
+// Refresh fetches the keys and replaces the cached ones
+func (km *KeyManager) Refresh() error {
+	km.refreshMu.Lock()
+	defer km.refreshMu.Unlock()
+	return km.refreshLocked()
+}
+
+func (km *KeyManager) refreshLocked() error {
+	km.mu.Lock()
+	km.lastFetch = time.Now()
+	km.mu.Unlock()
+
+	var keys map[string]*rsa.PublicKey
+	var err error
+	if km.jwksURL != "" {
+		keys, err = km.fetchJWKS()
+	} else {
+		keys, err = km.fetchPEM()
+	}
+
+	if err == nil {
+		km.mu.Lock()
+		km.keys = keys
+		km.mu.Unlock()
+	}
+
+	if err != nil {
+		return err
+	}
+	log.Printf("Loaded %d signing key(s)", len(keys))
+	return nil
+}
+
+func (km *KeyManager) fetchJWKS() (map[string]*rsa.PublicKey, error) {
+	resp, err := km.client.Get(km.jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks status %s", resp.Status)
+	}
+
+	var doc jwks
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid jwks: %w", err)
+	}
+	keys := make(map[string]*rsa.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		key, err := parseRSAJWK(k)
+		if err != nil {
+			log.Printf("Skipping key %s: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("jwks has no RSA signing key")
+	}
+	return keys, nil
+}
+
+func (km *KeyManager) fetchPEM() (map[string]*rsa.PublicKey, error) {
+	pem, err := GetPublicKey(km.pemURL)
+	if err != nil {
+		return nil, err
+	}
+	key, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	return map[string]*rsa.PublicKey{"": key}, nil
+}
+
+func parseRSAJWK(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 || exponent.Int64() < 3 {
+		return nil, errors.New("invalid exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+// Key returns the key of a kid. An unknown kid refreshes the keys (the user service may have rotated them).
+// Only the first unknown kid of minKeyRefresh waits for the fetch , the others fail right away.
+func (km *KeyManager) Key(kid string) (*rsa.PublicKey, error) {
+	if key, ok := km.lookup(kid); ok {
+		return key, nil
+	}
+	if !km.claimRefresh() {
+		return nil, errUnknownKey
+	}
+
+	km.refreshMu.Lock()
+	defer km.refreshMu.Unlock()
+	// a background refresh may have loaded it while we waited
+	if key, ok := km.lookup(kid); ok {
+		return key, nil
+	}
+	if err := km.refreshLocked(); err != nil {
+		log.Printf("Warning: Failed to refresh the signing keys: %v", err)
+	}
+	if key, ok := km.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, errUnknownKey
+}
+
+// claimRefresh reports whether the caller may fetch the keys , at most once per minKeyRefresh
+// (a fetch in progress counts)
+func (km *KeyManager) claimRefresh() bool {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	if time.Since(km.lastFetch) < minKeyRefresh {
+		return false
+	}
+	km.lastFetch = time.Now()
+	return true
+}
+
+func (km *KeyManager) lookup(kid string) (*rsa.PublicKey, bool) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	if key, ok := km.keys[kid]; ok {
+		return key, true
+	}
+	// tokens without kid are fine while there is only one key ,
+	// and the legacy PEM key (no kid) verifies every token
+	if len(km.keys) == 1 {
+		_, legacy := km.keys[""]
+		if legacy || kid == "" {
+			for _, key := range km.keys {
+				return key, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Keyfunc selects the verification key of a token by its kid header
+func (km *KeyManager) Keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	return km.Key(kid)
+}
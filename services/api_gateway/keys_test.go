@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newJWK(t *testing.T, kid string) (*rsa.PrivateKey, jwk) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, jwk{
+		Kty: "RSA",
+		Use: "sig",
+		Kid: kid,
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string) *jwt.Token {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{Subject: "1"})
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(signed, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestKeyManagerRotation(t *testing.T) {
+	oldKey, oldJWK := newJWK(t, "old")
+	newKey, newJWK := newJWK(t, "new")
+
+	var rotated atomic.Bool
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		doc := jwks{Keys: []jwk{oldJWK}}
+		if rotated.Load() {
+			doc.Keys = append(doc.Keys, newJWK)
+		}
+		json.NewEncoder(w).Encode(doc)
+	}))
+	defer srv.Close()
+
+	km := NewKeyManager(srv.URL, "", time.Hour)
+	if err := km.Refresh(); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+
+	key, err := km.Keyfunc(signToken(t, oldKey, "old"))
+	if err != nil || !key.(*rsa.PublicKey).Equal(&oldKey.PublicKey) {
+		t.Fatalf("Keyfunc(old) = %v, %v", key, err)
+	}
+
+	// the user service rotates its key , the unknown kid triggers a refresh
+	rotated.Store(true)
+	km.lastFetch = time.Time{}
+	key, err = km.Keyfunc(signToken(t, newKey, "new"))
+	if err != nil || !key.(*rsa.PublicKey).Equal(&newKey.PublicKey) {
+		t.Fatalf("Keyfunc(new) = %v, %v", key, err)
+	}
+
+	// unknown kids can`t refresh again before minKeyRefresh
+	before := fetches.Load()
+	if _, err := km.Key("random"); err != errUnknownKey {
+		t.Errorf("Key(random) error = %v, want errUnknownKey", err)
+	}
+	if fetches.Load() != before {
+		t.Errorf("unknown kid refreshed the keys again within %v", minKeyRefresh)
+	}
+}
+
+func TestKeyManagerMissDoesNotWait(t *testing.T) {
+	_, oldJWK := newJWK(t, "old")
+	release := make(chan struct{})
+	var slow atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slow.Load() {
+			<-release
+		}
+		json.NewEncoder(w).Encode(jwks{Keys: []jwk{oldJWK}})
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	km := NewKeyManager(srv.URL, "", time.Hour)
+	if err := km.Refresh(); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+	// a refresh is stuck on the user service
+	slow.Store(true)
+	km.lastFetch = time.Time{}
+	go km.Key("first")
+	time.Sleep(20 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := km.Key("random")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != errUnknownKey {
+			t.Errorf("Key(random) error = %v, want errUnknownKey", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a rate limited miss waits for the fetch in progress")
+	}
+}
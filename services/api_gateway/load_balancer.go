@@ -1,43 +1,96 @@
 package main
 
 //###################################
-// NOTE: All code in comment was used during local deploy game
-// Now, K8s Services handle load balancing via kube-proxy/iptables.
-// Gateway now holds gRPC connections to K8s service DNS names aka serviceAccounts.
-// Path: Ingress -> Gateway -> K8s Service (DNS) -> Pod
+// NOTE: kube-proxy balances connections , not calls: a long-lived HTTP/2 connection
+// to a ClusterIP pins all the calls of a gateway to one pod.
+// The gateway balances the calls itself over the instances found by the registry of each backend
+// (static: every IP of a headless service , dns-srv , etcd) and skips the ones that are not SERVING on grpc.health.v1
+// Path: Ingress -> Gateway -> Pod
 //###################################
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"maps"
 
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/logging"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer/leastrequest"
+	"google.golang.org/grpc/balancer/roundrobin"
 	"google.golang.org/grpc/credentials/insecure"
+	// the client side health checking of the subchannels
+	_ "google.golang.org/grpc/health"
 )
 
 type ServiceConnections struct {
-	conns map[string]*grpc.ClientConn
+	conns      map[string]*grpc.ClientConn
+	breakers   map[string]*backendBreakers
+	registries map[string]Registry
 }
 
-func NewServiceConnections(k8sServices map[string]string) (*ServiceConnections, error) {
-	sc := &ServiceConnections{conns: make(map[string]*grpc.ClientConn)}
-	for serviceName, addr := range k8sServices {
-		conn, err := grpc.NewClient(addr,
+func NewServiceConnections(k8sServices map[string]*models.BackendConfig, registryConfig models.RegisteryConfig) (*ServiceConnections, error) {
+	registries, err := newRegistries(registryConfig, k8sServices)
+	if err != nil {
+		return nil, err
+	}
+	sc := &ServiceConnections{conns: make(map[string]*grpc.ClientConn), breakers: make(map[string]*backendBreakers), registries: registries}
+	for serviceName, backend := range k8sServices {
+		unary := []grpc.UnaryClientInterceptor{logging.UnaryClientInterceptor}
+		stream := []grpc.StreamClientInterceptor{logging.StreamClientInterceptor}
+		// the breaker fails fast before the call is observed
+		if !backend.Breaker.Disabled {
+			breakers := newBackendBreakers(serviceName, backend.Breaker)
+			sc.breakers[serviceName] = breakers
+			unary = append(unary, breakers.unaryInterceptor())
+			stream = append(stream, breakers.streamInterceptor())
+		}
+		unary = append(unary, metricsUnaryInterceptor(serviceName))
+		stream = append(stream, metricsStreamInterceptor(serviceName))
+
+		// the registry of the backend sends its instances to the balancer
+		conn, err := grpc.NewClient(registryScheme+":///"+serviceName,
+			grpc.WithResolvers(&registryResolver{registry: registries[backend.Registry]}),
 			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithDefaultServiceConfig(serviceConfig(backend)),
+			grpc.WithChainUnaryInterceptor(unary...),
+			grpc.WithChainStreamInterceptor(stream...),
+			// client spans , the trace context goes to the backend as gRPC metadata
+			grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
 		)
 		if err != nil {
-			log.Printf("failed to connect to %s at %s: %v", serviceName, addr, err)
+			log.Printf("failed to connect to %s at %s: %v", serviceName, backend.Addr, err)
 			continue
 		}
 		sc.conns[serviceName] = conn
-		log.Printf("Connected to K8s service: %s -> %s", serviceName, addr)
+		log.Printf("Connected to K8s service: %s -> %s (%s , %s)", serviceName, backend.Addr, backend.Registry, backend.Balancer)
 	}
 	if len(sc.conns) == 0 {
+		closeRegistries(registries)
 		return nil, fmt.Errorf("no service connections established")
 	}
 	return sc, nil
 }
 
+// serviceConfig is the gRPC service config of a backend: its balancing policy over the resolved
+// addresses and the health checking of each of them
+func serviceConfig(backend *models.BackendConfig) string {
+	policy := map[string]any{roundrobin.Name: struct{}{}}
+	if backend.Balancer == "least_request" {
+		// the less loaded of 2 random instances
+		policy = map[string]any{leastrequest.Name: map[string]any{"choiceCount": 2}}
+	}
+	config := map[string]any{"loadBalancingConfig": []any{policy}}
+	if !backend.HealthCheck.Disabled {
+		// instances without the health service (Unimplemented) are considered healthy
+		config["healthCheckConfig"] = map[string]string{"serviceName": backend.HealthCheck.Service}
+	}
+	b, _ := json.Marshal(config)
+	return string(b)
+}
+
 func (sc *ServiceConnections) GetConn(serviceName string) (*grpc.ClientConn, error) {
 	conn, exists := sc.conns[serviceName]
 	if !exists {
@@ -46,146 +99,20 @@ func (sc *ServiceConnections) GetConn(serviceName string) (*grpc.ClientConn, err
 	return conn, nil
 }
 
+// BreakerStates are the circuit breaker states of all backends by breaker name
+func (sc *ServiceConnections) BreakerStates() map[string]string {
+	states := make(map[string]string)
+	for _, breakers := range sc.breakers {
+		maps.Copy(states, breakers.states())
+	}
+	return states
+}
+
 func (sc *ServiceConnections) close() {
 	for name, conn := range sc.conns {
 		if err := conn.Close(); err != nil {
 			log.Printf("error closing connection to %s: %v", name, err)
 		}
 	}
+	closeRegistries(sc.registries)
 }
-
-//==============================
-// OLD ONE
-//==============================
-
-// type LoadBalancer struct {
-// 	// ctx       context.Context
-// 	// cancel    context.CancelFunc
-// 	// wg        sync.WaitGroup
-// 	Balancers map[string]*RoundRobin
-// 	// client    *etcd.Client
-// }
-
-// type RoundRobin struct {
-// 	mu    sync.RWMutex
-// 	idx   atomic.Uint32
-// 	conns []*Service
-// }
-
-// type Service struct {
-// 	conn *grpc.ClientConn
-// 	ID   string
-// }
-
-// func NewLoadBalancer(k8sServices map[string]string) (*LoadBalancer, error) {
-// 	lb := &LoadBalancer{Balancers: make(map[string]*RoundRobin)}
-// 	for serviceName, addr := range k8sServices {
-// 		conn, err := grpc.NewClient(addr,
-// 			grpc.WithTransportCredentials(insecure.NewCredentials()),
-// 		)
-// 		if err != nil {
-// 			log.Printf("failed to connect to %s at %s: %v", serviceName, addr, err)
-// 			continue
-// 		}
-// 		rr := &RoundRobin{conns: []*Service{{conn: conn, ID: serviceName}}}
-// 		lb.Balancers[serviceName] = rr
-// 		log.Printf("Connected to service: %s -> %s", serviceName, addr)
-// 	}
-// 	return lb, nil
-// }
-
-// func (lb *LoadBalancer) close() {
-// 	for _, b := range lb.Balancers {
-// 		b.close()
-// 	}
-// }
-
-// func (r *RoundRobin) close() {
-// 	r.mu.Lock()
-// 	defer r.mu.Unlock()
-// 	for _, srv := range r.conns {
-// 		srv.conn.Close()
-// 	}
-// }
-
-// func (r *RoundRobin) ServiceConn() (*grpc.ClientConn, error) {
-// 	if len(r.conns) == 0 {
-// 		return nil, errors.New("no healthy instance for this service now")
-// 	}
-// 	r.mu.RLock()
-// 	defer r.mu.RUnlock()
-// 	idx := r.idx.Add(1) % uint32(len(r.conns))
-// 	return r.conns[idx].conn, nil
-// }
-
-// func (r *RoundRobin) ping(pingInterval time.Duration) {
-
-// 	ticker := time.NewTicker(pingInterval)
-// 	defer ticker.Stop()
-// 	client := &http.Client{Timeout: 2 * time.Second}
-// 	// intial healthCheck
-// 	r.healthCheck(client)
-
-// 	//start checking prediocally
-// 	for {
-// 		select {
-// 		case <-r.ctx.Done():
-// 			return
-// 		case <-ticker.C:
-// 			r.healthCheck(client)
-// 		}
-// 	}
-// }
-
-// func (r *RoundRobin) healthCheck(client *http.Client) {
-// 	for _, srv := range r.conns {
-// 		resp, err := client.Get(healthEnd(srv.URL))
-// 		if err == nil && resp.StatusCode == http.StatusOK {
-// 			if !srv.healthy.Load() {
-// 				log.Printf("Service %s is now healthy", srv.URL)
-// 			}
-// 			// log.Printf("Service %s is healthy", srv.URL)
-// 			srv.healthy.Store(true)
-// 			resp.Body.Close() // close body
-// 		} else {
-// 			if srv.healthy.Load() {
-// 				log.Printf("Service %s is now unhealthy: %v", srv.URL, err)
-// 			}
-// 			// log.Printf("Service %s is  unhealthy", srv.URL)
-// 			srv.healthy.Store(false)
-// 		}
-// 	}
-
-// }
-
-// func (r *RoundRobin) close() {
-// 	// End Ping
-// 	// r.cancel()
-// 	for _, srv := range r.conns {
-// 		if srv.conn != nil {
-// 			srv.conn.Close()
-// 		}
-// 	}
-// }
-
-// func getKeyValID(kv *mvccpb.KeyValue) (string, string, string) {
-// 	key, val := strings.Split(string(kv.Key), "/"), string(kv.Value)
-// 	return key[2], val, key[3]
-// }
-
-// func getKeyID(ev *etcd.Event) (string, string) {
-// 	key := strings.Split(string(ev.Kv.Key), "/")
-// 	return key[2], key[3]
-// }
-
-// func healthEnd(addr string) string {
-// 	parts := strings.Split(addr, ":")
-// 	if len(parts) != 2 {
-// 		return ""
-// 	}
-
-// 	hostname := parts[0]
-// 	healthURL := fmt.Sprintf("http://%s:8080/health", hostname)
-
-// 	return healthURL
-// }
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestServiceConfig(t *testing.T) {
+	for _, backend := range []*models.BackendConfig{
+		{Addr: "feed-service:50081", Balancer: "round_robin"},
+		{Addr: "feed-service:50081", Balancer: "least_request", HealthCheck: models.HealthCheckConfig{Service: "feed.FeedService"}},
+		{Addr: "feed-service:50081", Balancer: "round_robin", HealthCheck: models.HealthCheckConfig{Disabled: true}},
+	} {
+		config := serviceConfig(backend)
+		// an invalid service config fails the client creation
+		conn, err := grpc.NewClient(backend.Addr,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithDefaultServiceConfig(config),
+		)
+		if err != nil {
+			t.Fatalf("%s: %v", config, err)
+		}
+		conn.Close()
+		if strings.Contains(config, "healthCheckConfig") == backend.HealthCheck.Disabled {
+			t.Errorf("health check disabled = %v , config %s", backend.HealthCheck.Disabled, config)
+		}
+	}
+}
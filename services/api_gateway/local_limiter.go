@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// LocalLimiter is the degraded mode of the rateLimiter when the redis cluster is down.
+// Every gateway replica keeps its own token buckets in memory, so the rule
+// limits are divided by the number of replicas to approximate the global limit.
+type LocalLimiter struct {
+	mu       sync.Mutex
+	buckets  map[string]*localBucket
+	replicas int
+	idleTTL  time.Duration
+}
+
+type localBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+func NewLocalLimiter(ctx context.Context, replicas int) *LocalLimiter {
+	if replicas <= 0 {
+		replicas = 1
+	}
+	ll := &LocalLimiter{
+		buckets:  make(map[string]*localBucket),
+		replicas: replicas,
+		idleTTL:  5 * time.Minute,
+	}
+	go ll.cleanup(ctx)
+	return ll
+}
+
+// Allow checks the rule with a token bucket whatever the rule algorithm is.
+// Window algorithms are converted to limit per window as refill rate.
+func (ll *LocalLimiter) Allow(id string, rule Rule) *RateLimitInfo {
+	limit, rate := ll.perReplica(rule)
+
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	now := time.Now()
+	b, ok := ll.buckets[id]
+	if !ok {
+		b = &localBucket{tokens: limit, lastRefill: now}
+		ll.buckets[id] = b
+	}
+	allowed := b.take(limit, rate, now)
+	retryAfter := 0
+	if !allowed && rate > 0 {
+		retryAfter = int(math.Ceil((1 - b.tokens) / rate))
+	}
+	return &RateLimitInfo{
+		Allowed:           allowed,
+		Remaining:         int(b.tokens),
+		Limit:             int(limit),
+		RetryAfterSeconds: retryAfter,
+	}
+}
+
+// perReplica returns the bucket size and refill rate (tokens/s) of this replica share
+func (ll *LocalLimiter) perReplica(rule Rule) (float64, float64) {
+	limit := float64(rule.Limit)
+	rate := float64(rule.RefillRate)
+	switch rule.Algorithm {
+	case SlidingWindowLog, SlidingWindowCounter, FixedWindow:
+		rate = limit / (float64(rule.WindowMs) / 1000)
+	}
+	replicas := float64(ll.replicas)
+	// at least one request per replica
+	return math.Max(1, math.Floor(limit/replicas)), rate / replicas
+}
+
+// take refills the bucket up to limit and takes one token if there is any
+func (b *localBucket) take(limit, rate float64, now time.Time) bool {
+	delta := now.Sub(b.lastRefill).Seconds()
+	b.tokens = math.Min(limit, b.tokens+rate*delta)
+	b.lastRefill = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// cleanup removes the buckets that are not used for a while to avoid memory leaks
+func (ll *LocalLimiter) cleanup(ctx context.Context) {
+	ticker := time.NewTicker(ll.idleTTL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ll.mu.Lock()
+			for id, b := range ll.buckets {
+				if time.Since(b.lastRefill) > ll.idleTTL {
+					delete(ll.buckets, id)
+				}
+			}
+			ll.mu.Unlock()
+		}
+	}
+}
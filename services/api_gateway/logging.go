@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/logging"
+)
+
+// requestIDHeader is generated by the gateway (or kept from the client) and forwarded to the backends
+// as logging.RequestIDMetadata (see logging.UnaryClientInterceptor)
+const requestIDHeader = "X-Request-Id"
+
+// newRequestID keeps a sane client id , otherwise generates one
+func newRequestID(clientID string) string {
+	if clientID != "" && len(clientID) <= 128 && !strings.ContainsFunc(clientID, func(r rune) bool { return r < 0x21 || r > 0x7e }) {
+		return clientID
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// InitLogger sets the shared logger of the services , with the resolved client ip of the request
+func InitLogger() {
+	logging.Init("api_gateway", clientIPAttrs)
+}
+
+func clientIPAttrs(ctx context.Context) []slog.Attr {
+	if ip := clientIPFromContext(ctx); ip != "" {
+		return []slog.Attr{slog.String("client_ip", ip)}
+	}
+	return nil
+}
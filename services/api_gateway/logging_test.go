@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/logging"
+)
+
+func TestLoggerClientIP(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(logging.NewContextHandler(slog.NewJSONHandler(&buf, &slog.HandlerOptions{ReplaceAttr: logging.Redact}), clientIPAttrs))
+
+	ctx := withClientIP(logging.WithRequestID(context.Background(), "req-1"), "198.51.100.1")
+	logger.InfoContext(ctx, "request", "header", "Bearer abc.def")
+
+	out := buf.String()
+	for _, kept := range []string{`"request_id":"req-1"`, `"client_ip":"198.51.100.1"`} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("%s is missing: %s", kept, out)
+		}
+	}
+	if strings.Contains(out, "abc.def") {
+		t.Errorf("token is logged: %s", out)
+	}
+}
+
+func TestNewRequestID(t *testing.T) {
+	if got := newRequestID("abc-123"); got != "abc-123" {
+		t.Errorf("client id = %q , want abc-123", got)
+	}
+	for _, bad := range []string{"", "has space", strings.Repeat("a", 129), "new\nline"} {
+		if got := newRequestID(bad); got == bad || len(got) != 32 {
+			t.Errorf("newRequestID(%q) = %q", bad, got)
+		}
+	}
+}
@@ -6,10 +6,14 @@ import (
 	"net/http"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/tracing"
 	"github.com/redis/go-redis/v9"
 )
 
+const configPath = "config.yaml"
+
 func main() {
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
@@ -18,10 +22,21 @@ func main() {
 
 	// ctx := context.Background()
 
-	config, err := LoadAppConfig("config.yaml")
+	config, err := LoadAppConfig(configPath)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	shutdownTracing, err := tracing.Init(ctx, tracing.Config{
+		ServiceName: config.Tracing.ServiceName,
+		Exporter:    config.Tracing.Exporter,
+		Endpoint:    config.Tracing.Endpoint,
+		File:        config.Tracing.File,
+		SampleRatio: *config.Tracing.SampleRatio,
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize tracing: %v", err)
+	}
+
 	err = loadLuaScripts(config)
 	if err != nil {
 		log.Fatalf("Failed to LuaScripts : %v", err)
@@ -32,27 +47,11 @@ func main() {
 	}
 	log.Println("Rate limiter initialized")
 
-	serviceConns, err := NewServiceConnections(config.K8sServices)
+	serviceConns, err := NewServiceConnections(config.K8sServices, config.ServiceRegistery)
 	if err != nil {
 		rateLimiter.close()
 		log.Fatalf("Failed to initialize service connections: %v", err)
 	}
-	grpcInvoker := NewGRPCInvoker(config.RouteOptions)
-	log.Println("gRPC invoker initialized")
-
-	for serviceName, protofile := range config.ProtoFiles {
-		if protofile == "" {
-			log.Printf("Warning: No protoset path configured for service %s", serviceName)
-			continue
-		}
-
-		err := grpcInvoker.LoadProtoset(protofile, serviceName)
-		if err != nil {
-			log.Printf("Warning: Failed to load protoset for service %s: %v", serviceName, err)
-		} else {
-			log.Printf("Successfully loaded protoset for %s from %s", serviceName, protofile)
-		}
-	}
 
 	// redisPool, err := NewRedisPool(config.Redis.RedisAddr, config.Redis.RedisPoolSize)
 	// if err != nil {
@@ -63,7 +62,7 @@ func main() {
 	// 	log.Fatalf("Failed to create Redis pool: %v", err)
 	// }
 	redis := redis.NewClient(&redis.Options{Addr: config.Redis.RedisAddr})
-	handler := NewHandler(config, serviceConns, grpcInvoker, rateLimiter, redis)
+	handler := NewHandler(config, serviceConns, rateLimiter, redis)
 	if handler == nil {
 		rateLimiter.close()
 		// grpcInvoker.close()
@@ -72,6 +71,13 @@ func main() {
 		log.Fatal("Failed to create handler")
 	}
 
+	// Watch config files & SIGHUP to reload routes and rules
+	reloader := NewReloader(configPath, handler, config.Reload.WatchInterval)
+	go reloader.Run(ctx)
+	go handler.WatchDescriptors(ctx, config.Descriptors.RefreshInterval)
+	// JWT signing keys , refreshed so the user service can rotate them
+	go handler.keys.Run(ctx)
+
 	// Initialize and start server
 	server := NewServer(handler, config)
 	log.Printf("Starting API Gateway on %s:%s", config.Server.Host, config.Server.Port)
@@ -92,4 +98,11 @@ func main() {
 	stop()
 
 	server.Close()
+
+	// flush the pending spans
+	tctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(tctx); err != nil {
+		log.Printf("Error in flushing spans: %v", err)
+	}
 }
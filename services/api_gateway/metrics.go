@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Prometheus metrics served on /metrics
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_http_requests_total",
+		Help: "HTTP requests by route template , method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_http_request_duration_seconds",
+		Help:    "HTTP request latency by route template , method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	httpInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gateway_http_requests_in_flight",
+		Help: "HTTP requests being served (streams and WebSockets included).",
+	})
+
+	grpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gateway_grpc_request_duration_seconds",
+		Help:    "gRPC call latency by backend , method and gRPC code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"backend", "method", "code"})
+
+	grpcRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_grpc_retries_total",
+		Help: "Extra calls to a backend by kind (retry or hedge).",
+	}, []string{"backend", "kind"})
+
+	retryBudgetExhausted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_retry_budget_exhausted_total",
+		Help: "Retries and hedged calls skipped because the retry budget of the backend is spent.",
+	}, []string{"backend"})
+
+	rateLimitDecisions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_ratelimit_decisions_total",
+		Help: "Rate limit decisions by rule (allowed or denied).",
+	}, []string{"rule", "decision"})
+
+	rateLimitFallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_ratelimit_fallbacks_total",
+		Help: "Rate limit checks done without redis by failure mode (open allows everything).",
+	}, []string{"mode"})
+
+	tokenFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_token_validation_failures_total",
+		Help: "Rejected access tokens by reason.",
+	}, []string{"reason"})
+
+	redisErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_redis_errors_total",
+		Help: "Redis errors by component , every one is a fail-open or fallback path.",
+	}, []string{"component"})
+
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_cache_requests_total",
+		Help: "Response cache lookups by route template and result (hit , miss , bypass , not_modified).",
+	}, []string{"route", "result"})
+
+	coalescedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_coalesced_requests_total",
+		Help: "Requests of the shareable routes served by a backend call shared with identical requests.",
+	}, []string{"route"})
+
+	backendInstances = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_backend_instances",
+		Help: "Instances of a backend found by its service registry.",
+	}, []string{"backend"})
+
+	breakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_circuit_breaker_state",
+		Help: "Circuit breaker state (0 closed , 1 open , 2 half-open) , per backend or backend method and redis.",
+	}, []string{"breaker"})
+
+	breakerTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_circuit_breaker_transitions_total",
+		Help: "Circuit breaker state changes by new state.",
+	}, []string{"breaker", "state"})
+
+	breakerRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_circuit_breaker_rejections_total",
+		Help: "Backend calls failed fast by an open circuit breaker.",
+	}, []string{"breaker"})
+)
+
+// labels of the requests that matched no route (unbounded paths must not become labels)
+const unmatchedRoute = "unmatched"
+
+// Redis components of gateway_redis_errors_total
+const (
+	redisRateLimiter   = "ratelimiter"
+	redisTokenDenylist = "token_denylist"
+	redisTokenRevoke   = "token_revoke"
+	redisResponseCache = "response_cache"
+)
+
+func decision(allowed bool) string {
+	if allowed {
+		return "allowed"
+	}
+	return "denied"
+}
+
+// statusRecorder keeps the status code of the response for the metrics.
+// Flush and the deadlines go through Unwrap (http.ResponseController) , Hijack is needed by the WebSocket upgrader.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.status == 0 {
+		sr.status = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(b)
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+func (sr *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := sr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not implement http.Hijacker")
+	}
+	// the WebSocket handshake answers 101
+	sr.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+func observeRequest(route, method string, status int, elapsed time.Duration) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	code := strconv.Itoa(status)
+	httpRequests.WithLabelValues(route, method, code).Inc()
+	httpDuration.WithLabelValues(route, method, code).Observe(elapsed.Seconds())
+}
+
+// metricsUnaryInterceptor observes the latency of the unary calls to a backend
+func metricsUnaryInterceptor(backend string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		grpcDuration.WithLabelValues(backend, method, status.Code(err).String()).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// metricsStreamInterceptor observes the time to open the streams to a backend
+// (streams last as long as the client wants , their whole duration is not a latency)
+func metricsStreamInterceptor(backend string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		grpcDuration.WithLabelValues(backend, method, status.Code(err).String()).Observe(time.Since(start).Seconds())
+		return stream, err
+	}
+}
+
+// tokenFailureReason is the metrics reason of a JWT parse error
+func tokenFailureReason(err error) string {
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return "malformed"
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return "bad_signature"
+	case errors.Is(err, jwt.ErrTokenInvalidAudience), errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return "bad_claims"
+	case errors.Is(err, jwt.ErrTokenNotValidYet):
+		return "not_valid_yet"
+	}
+	return "invalid"
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc/codes"
+)
+
+func TestStatusRecorder(t *testing.T) {
+	w := httptest.NewRecorder()
+	recorder := &statusRecorder{ResponseWriter: w}
+	writeError(recorder, codes.ResourceExhausted, "RATE_LIMIT_EXCEEDED", "Rate limit exceeded")
+	if recorder.status != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", recorder.status)
+	}
+	// the streams flush through Unwrap
+	if err := http.NewResponseController(recorder).Flush(); err != nil {
+		t.Errorf("Flush: %v", err)
+	}
+
+	before := testutil.ToFloat64(httpRequests.WithLabelValues("/api/v1/feed", "GET", "429"))
+	observeRequest("/api/v1/feed", "GET", recorder.status, time.Millisecond)
+	if got := testutil.ToFloat64(httpRequests.WithLabelValues("/api/v1/feed", "GET", "429")); got != before+1 {
+		t.Errorf("requests = %v, want %v", got, before+1)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/codes"
+)
+
+// requestContext is the state of one request shared by the middlewares of its route
+type requestContext struct {
+	w          http.ResponseWriter
+	r          *http.Request
+	st         *gatewayState
+	route      *models.RouteConfig
+	pathParams map[string]string
+	tokens     requestTokens
+	userID     string        // set by the auth middleware
+	claims     jwt.MapClaims // of the access token , set by the auth middleware
+	cacheKey   string        // set by the cache middleware on a miss , the response is stored under it
+}
+
+// Middleware is one step of a route pipeline.
+// Before runs in order before the backend call , After runs in order on the unary responses
+// before they are written (ex: set cookies from the response). Both return false when they
+// wrote the response themselves and the request must stop.
+type Middleware struct {
+	Name   string
+	Before func(h *Handler, rc *requestContext) bool
+	After  func(h *Handler, rc *requestContext, response []byte) bool
+}
+
+// middlewareFactories builds the middlewares by the names used in route_options
+var middlewareFactories = map[string]func(params map[string]string) (*Middleware, error){
+	"cors":              newCORSMiddleware,
+	"ratelimit":         newRateLimitMiddleware,
+	"csrf":              newCSRFMiddleware,
+	"auth":              newAuthMiddleware,
+	"user-ratelimit":    newUserRateLimitMiddleware,
+	"set-token-cookies": newSetTokenCookiesMiddleware,
+	"revoke-token":      newRevokeTokenMiddleware,
+	"cache":             newCacheMiddleware,
+	"invalidate-cache":  newInvalidateCacheMiddleware,
+}
+
+// defaultMiddlewares is the pipeline of the routes that only set require_auth / rate_limit_enabled
+func defaultMiddlewares(route *models.RouteConfig) []models.MiddlewareConfig {
+	chain := []models.MiddlewareConfig{{Name: "cors"}}
+	if route.RateLimitEnabled {
+		chain = append(chain, models.MiddlewareConfig{Name: "ratelimit"})
+	}
+	chain = append(chain, models.MiddlewareConfig{Name: "csrf"})
+	if route.RequireAuth {
+		chain = append(chain, models.MiddlewareConfig{Name: "auth"})
+	}
+	return append(chain, models.MiddlewareConfig{Name: "user-ratelimit"})
+}
+
+// buildPipeline builds the middlewares of a route from its route_options.
+// A configured list also sets require_auth and rate_limit_enabled (used by the docs and the CSRF check).
+func buildPipeline(route *models.RouteConfig, configs []models.MiddlewareConfig) ([]*Middleware, error) {
+	if len(configs) == 0 {
+		configs = defaultMiddlewares(route)
+	} else {
+		route.RequireAuth = middlewareIndex(configs, "auth") >= 0
+		route.RateLimitEnabled = middlewareIndex(configs, "ratelimit") >= 0
+	}
+	// the cookies are sent by the browser on any cross site request , a cookie authenticated
+	// write is only safe once csrf checked it
+	if auth := middlewareIndex(configs, "auth"); auth >= 0 && middlewareIndex(configs[:auth], "csrf") < 0 {
+		return nil, errors.New("auth needs csrf before it")
+	}
+
+	// the cache runs last , after the auth and the rate limits
+	configs = append(slices.Clip(configs), cacheMiddlewares(route, configs)...)
+	// every authenticated call carries its user to the backend , the response is personal
+	if route.Shareable && route.RequireAuth {
+		return nil, errors.New("shareable needs a route without auth")
+	}
+	if cache := middlewareIndex(configs, "cache"); cache >= 0 && route.RequireAuth && configs[cache].Params["per_user"] != "true" {
+		return nil, errors.New("cache of an authenticated route must be per_user")
+	}
+
+	pipeline := make([]*Middleware, 0, len(configs))
+	for _, cfg := range configs {
+		factory, ok := middlewareFactories[cfg.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware %q", cfg.Name)
+		}
+		m, err := factory(cfg.Params)
+		if err != nil {
+			return nil, fmt.Errorf("middleware %s: %w", cfg.Name, err)
+		}
+		m.Name = cfg.Name
+		pipeline = append(pipeline, m)
+	}
+	return pipeline, nil
+}
+
+func middlewareIndex(configs []models.MiddlewareConfig, name string) int {
+	return slices.IndexFunc(configs, func(c models.MiddlewareConfig) bool { return c.Name == name })
+}
+
+// cors allows the cross origin requests of the route (the cors section , or its route_options override).
+// The preflights are answered before the route lookup.
+func newCORSMiddleware(params map[string]string) (*Middleware, error) {
+	return &Middleware{
+		Before: func(h *Handler, rc *requestContext) bool {
+			if p := rc.st.corsFor(rc.route); p != nil {
+				p.apply(rc.w, rc.r)
+			}
+			return true
+		},
+	}, nil
+}
+
+// ratelimit applies the IP rule
+func newRateLimitMiddleware(params map[string]string) (*Middleware, error) {
+	return &Middleware{
+		Before: func(h *Handler, rc *requestContext) bool {
+			rateLimitInfo, err := h.rateLimiter.AllowIP(rc.r, rc.st.rules)
+			if err != nil {
+				slog.WarnContext(rc.r.Context(), "rate limiter error", "error", err)
+				// Fail open
+				return true
+			}
+			if rateLimitInfo == nil {
+				return false
+			}
+			return writeRateLimit(rc.w, rateLimitInfo)
+		},
+	}, nil
+}
+
+// writeRateLimit sets the X-Ratelimit headers of a checked rule , and writes the 429 if it is exceeded.
+// It reports whether the request can go on.
+func writeRateLimit(w http.ResponseWriter, info *RateLimitInfo) bool {
+	if info.Limit > 0 || !info.Allowed {
+		w.Header().Set("X-Ratelimit-Remaining", fmt.Sprintf("%d", info.Remaining))
+		w.Header().Set("X-Ratelimit-Limit", fmt.Sprintf("%d", info.Limit))
+	}
+	if info.Allowed {
+		return true
+	}
+	if info.RetryAfterSeconds > 0 {
+		w.Header().Set("X-Ratelimit-Retry-After", fmt.Sprintf("%d", info.RetryAfterSeconds))
+	}
+	writeError(w, codes.ResourceExhausted, "RATE_LIMIT_EXCEEDED", "Rate limit exceeded", retryInfo(info.RetryAfterSeconds))
+	return false
+}
+
+// csrf makes browsers authenticated by cookies prove the request comes from our frontend
+func newCSRFMiddleware(params map[string]string) (*Middleware, error) {
+	return &Middleware{
+		Before: func(h *Handler, rc *requestContext) bool {
+			if needsCSRF(rc.r, rc.route, rc.tokens) && !checkCSRF(rc.r, rc.st.config.Auth) {
+				writeError(rc.w, codes.PermissionDenied, "CSRF_TOKEN_INVALID", "Missing or invalid CSRF token")
+				return false
+			}
+			return true
+		},
+	}, nil
+}
+
+// auth validates the access token and sets the user of the request
+func newAuthMiddleware(params map[string]string) (*Middleware, error) {
+	return &Middleware{
+		Before: func(h *Handler, rc *requestContext) bool {
+			claims, ok := h.checkAuth(rc.w, rc.tokens.access)
+			if !ok {
+				return false // checkAuth already wrote error
+			}
+			rc.userID, _ = claims.GetSubject()
+			rc.claims = claims
+			return true
+		},
+	}, nil
+}
+
+// user-ratelimit applies the rules of the route (and the per user rules if authenticated)
+func newUserRateLimitMiddleware(params map[string]string) (*Middleware, error) {
+	return &Middleware{
+		Before: func(h *Handler, rc *requestContext) bool {
+			if rc.userID == "" && len(rc.route.RateRules) == 0 {
+				return true
+			}
+			allowed, err := h.rateLimiter.AllowRules(rc.r, rc.st.rules, rc.route, rc.userID)
+			if err != nil {
+				slog.WarnContext(rc.r.Context(), "rate limiter error", "error", err)
+				// Fail open
+				return true
+			}
+			return writeRateLimit(rc.w, allowed)
+		},
+	}, nil
+}
+
+// set-token-cookies sets the token cookies from a login/refresh response
+func newSetTokenCookiesMiddleware(params map[string]string) (*Middleware, error) {
+	return &Middleware{
+		After: func(h *Handler, rc *requestContext, response []byte) bool {
+			var token models.Tokens
+			if err := json.Unmarshal(response, &token); err != nil {
+				slog.ErrorContext(rc.r.Context(), "tokens unmarshal failed", "error", err)
+				writeError(rc.w, codes.Internal, "INTERNAL", "Internal error")
+				return false
+			}
+			if err := setTokenCookies(rc.w, token, rc.st.config.Auth); err != nil {
+				slog.ErrorContext(rc.r.Context(), "failed to set token cookies", "error", err)
+				writeError(rc.w, codes.Internal, "INTERNAL", "Internal error")
+				return false
+			}
+			return true
+		},
+	}, nil
+}
+
+// revoke-token adds the access token to the denylist after a logout and clears the cookies.
+// ttl is how long the token stays revoked (at least the access token lifetime).
+func newRevokeTokenMiddleware(params map[string]string) (*Middleware, error) {
+	ttl := 5 * time.Minute
+	if v, ok := params["ttl"]; ok {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ttl: %w", err)
+		}
+		ttl = d
+	}
+	return &Middleware{
+		After: func(h *Handler, rc *requestContext, response []byte) bool {
+			accessToken := rc.tokens.access
+			if accessToken == "" {
+				slog.InfoContext(rc.r.Context(), "logout without access token")
+				writeError(rc.w, codes.Unauthenticated, "INVALID_TOKEN", "Invalid Access Token")
+				return false
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			if err := h.redis.Eval(ctx, rc.st.config.Redis.AddScript, []string{accessToken}, []interface{}{ttl}).Err(); err != nil {
+				slog.ErrorContext(rc.r.Context(), "failed to revoke token", "error", err)
+				redisErrors.WithLabelValues(redisTokenRevoke).Inc()
+			}
+			clearTokenCookies(rc.w, rc.st.config.Auth)
+			return true
+		},
+	}, nil
+}
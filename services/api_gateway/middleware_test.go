@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+)
+
+func pipelineNames(pipeline []*Middleware) []string {
+	names := make([]string, len(pipeline))
+	for i, m := range pipeline {
+		names[i] = m.Name
+	}
+	return names
+}
+
+func TestBuildPipeline(t *testing.T) {
+	// the flags give the default pipeline
+	route := &models.RouteConfig{RequireAuth: true}
+	pipeline, err := buildPipeline(route, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"cors", "csrf", "auth", "user-ratelimit"}
+	if got := pipelineNames(pipeline); !slices.Equal(got, want) {
+		t.Errorf("default pipeline = %v, want %v", got, want)
+	}
+
+	// a configured list sets the flags
+	route = &models.RouteConfig{RequireAuth: true}
+	_, err = buildPipeline(route, []models.MiddlewareConfig{{Name: "ratelimit"}, {Name: "revoke-token", Params: map[string]string{"ttl": "15m"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if route.RequireAuth || !route.RateLimitEnabled {
+		t.Errorf("flags = auth %v , rate_limit %v , want false , true", route.RequireAuth, route.RateLimitEnabled)
+	}
+
+	// cookie authenticated writes would skip the CSRF check
+	for _, configs := range [][]models.MiddlewareConfig{
+		{{Name: "cors"}, {Name: "auth"}},
+		{{Name: "auth"}, {Name: "csrf"}},
+	} {
+		if _, err := buildPipeline(&models.RouteConfig{}, configs); err == nil {
+			t.Errorf("%v accepted without csrf before auth", configs)
+		}
+	}
+
+	// the user is forwarded on every authenticated call , nothing could be shared
+	if _, err := buildPipeline(&models.RouteConfig{RequireAuth: true, Shareable: true}, nil); err == nil {
+		t.Error("shareable accepted on an authenticated route")
+	}
+
+	if _, err := buildPipeline(route, []models.MiddlewareConfig{{Name: "unknown"}}); err == nil {
+		t.Error("unknown middleware accepted")
+	}
+	if _, err := buildPipeline(route, []models.MiddlewareConfig{{Name: "revoke-token", Params: map[string]string{"ttl": "soon"}}}); err == nil {
+		t.Error("invalid ttl accepted")
+	}
+}
+
+func TestSetTokenCookiesHook(t *testing.T) {
+	pipeline, err := buildPipeline(&models.RouteConfig{}, []models.MiddlewareConfig{{Name: "set-token-cookies"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	rc := &requestContext{
+		w:  w,
+		r:  httptest.NewRequest("POST", "/api/v1/login", nil),
+		st: &gatewayState{config: &models.AppConfig{Auth: models.AuthConfig{CSRFCookie: "csrfToken", CSRFHeader: "X-CSRF-Token"}}},
+	}
+	if !pipeline[0].After(nil, rc, []byte(`{"Access":"a","Refresh":"r"}`)) {
+		t.Fatalf("hook stopped the request: %s", w.Body.String())
+	}
+	var access *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == accessTokenCookie {
+			access = c
+		}
+	}
+	if access == nil || access.Value != "a" {
+		t.Errorf("access cookie = %v", access)
+	}
+
+	w = httptest.NewRecorder()
+	rc.w = w
+	if pipeline[0].After(nil, rc, []byte(`not json`)) {
+		t.Error("invalid response accepted")
+	}
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", w.Code)
+	}
+}
+
+func TestWriteRateLimit(t *testing.T) {
+	w := httptest.NewRecorder()
+	if writeRateLimit(w, &RateLimitInfo{Allowed: false, Limit: 10, RetryAfterSeconds: 3}) {
+		t.Fatal("denied request went on")
+	}
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d , want 429", w.Code)
+	}
+	for header, want := range map[string]string{"X-Ratelimit-Limit": "10", "X-Ratelimit-Remaining": "0", "X-Ratelimit-Retry-After": "3"} {
+		if got := w.Header().Get(header); got != want {
+			t.Errorf("%s = %q , want %q", header, got, want)
+		}
+	}
+
+	// no rule of the route , the headers of the ip rule are kept
+	w = httptest.NewRecorder()
+	w.Header().Set("X-Ratelimit-Limit", "100")
+	if !writeRateLimit(w, &RateLimitInfo{Allowed: true}) || w.Header().Get("X-Ratelimit-Limit") != "100" {
+		t.Errorf("allowed request without rule = %v", w.Header())
+	}
+}
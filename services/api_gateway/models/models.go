@@ -1,30 +1,182 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
 
 type AppConfig struct {
-	Server       ServerConfig       `yaml:"server"`
-	RateLimiting RateLimitingConfig `yaml:"rate_limiting"`
-	Redis        RedisConfig        `yaml:"redis_config"`
-	// ServiceRegistery RegisteryConfig         `yaml:"service_registery"`
-	K8sServices  map[string]string       `yaml:"k8s_services"`
-	ProtoFiles   map[string]string       `yaml:"protoset_files"`
-	RouteOptions map[string]*RouteOption `yaml:"route_options"`
-	PublicKey    []byte
+	Server           ServerConfig              `yaml:"server"`
+	RateLimiting     RateLimitingConfig        `yaml:"rate_limiting"`
+	Redis            RedisConfig               `yaml:"redis_config"`
+	ServiceRegistery RegisteryConfig           `yaml:"service_registery"`
+	K8sServices      map[string]*BackendConfig `yaml:"k8s_services"`
+	ProtoFiles       map[string]string         `yaml:"protoset_files"`
+	Descriptors      DescriptorConfig          `yaml:"descriptors"`
+	RouteOptions     map[string]*RouteOption   `yaml:"route_options"`
+	Reload           ReloadConfig              `yaml:"reload"`
+	Streaming        StreamingConfig           `yaml:"streaming"`
+	WebSocket        WebSocketConfig           `yaml:"websocket"`
+	OpenAPI          OpenAPIConfig             `yaml:"openapi"`
+	Auth             AuthConfig                `yaml:"auth"`
+	CORS             CORSConfig                `yaml:"cors"`
+	Tracing          TracingConfig             `yaml:"tracing"`
+	Calls            CallsConfig               `yaml:"calls"`
+	Identity         IdentityConfig            `yaml:"identity"`
+}
+
+// IdentityConfig is the allowlist of the request context sent to the backends as gRPC metadata
+type IdentityConfig struct {
+	Forward []string `yaml:"forward"` // user_id , client_ip , request_id , user_agent (default: all)
+	Claims  []string `yaml:"claims"`  // JWT claims sent as x-claim-<name>
+}
+
+// CallsConfig is the default policy of the unary backend calls , route_options can override it
+type CallsConfig struct {
+	Timeout time.Duration `yaml:"timeout"` // deadline of the call including its retries
+	Retry   RetryConfig   `yaml:"retry"`
+}
+
+// RetryConfig retries the calls of the idempotent routes on Unavailable and ResourceExhausted
+// with exponential backoff and full jitter
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts"` // including the first call , 1 disables the retries
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	MaxBackoff     time.Duration `yaml:"max_backoff"`
+	// budget of a backend: retries (and hedged calls) are at most budget_ratio of its calls
+	// plus budget_min_retries per second , so a failing backend doesn`t get the traffic multiplied
+	BudgetRatio      float64 `yaml:"budget_ratio"`
+	BudgetMinRetries int     `yaml:"budget_min_retries"`
+}
+
+// HedgeConfig sends another call when the previous one has no response after Delay , the first response wins
+type HedgeConfig struct {
+	Delay       time.Duration `yaml:"delay"`
+	MaxAttempts int           `yaml:"max_attempts"` // calls in total , default 2
+}
+
+// TracingConfig exports the OpenTelemetry spans of the gateway
+type TracingConfig struct {
+	Exporter    string   `yaml:"exporter"`     // none (default) | otlp | stdout | file
+	Endpoint    string   `yaml:"endpoint"`     // otlp collector host:port (OTEL_EXPORTER_OTLP_ENDPOINT if empty)
+	File        string   `yaml:"file"`         // file exporter path
+	SampleRatio *float64 `yaml:"sample_ratio"` // of the new traces (default 1 , 0 keeps only the sampled callers)
+	ServiceName string   `yaml:"service_name"`
+}
+
+type DescriptorConfig struct {
+	Source          string        `yaml:"source"`           // protoset (default) | reflection
+	RefreshInterval time.Duration `yaml:"refresh_interval"` // reflection mode only
+}
+
+type ReloadConfig struct {
+	WatchInterval time.Duration `yaml:"watch_interval"` // 0 disables file watching (SIGHUP only)
+}
+
+// StreamingConfig limits the server-streaming routes (SSE / NDJSON)
+type StreamingConfig struct {
+	MaxDuration       time.Duration `yaml:"max_duration"`       // deadline of the gRPC stream if the client sets none
+	HeartbeatInterval time.Duration `yaml:"heartbeat_interval"` // SSE comments to keep idle connections open
+	WriteTimeout      time.Duration `yaml:"write_timeout"`      // slow clients are dropped after that
+}
+
+// WebSocketConfig limits the WebSocket bridges of the bidi-streaming routes
+type WebSocketConfig struct {
+	MessageRate     float64       `yaml:"message_rate"`      // messages/s per connection (0 disables the limit)
+	MessageBurst    int           `yaml:"message_burst"`     // defaults to message_rate
+	MaxMessageBytes int64         `yaml:"max_message_bytes"` // bigger frames close the connection
+	PingInterval    time.Duration `yaml:"ping_interval"`     // peers that miss 2 pings are dropped
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+}
+
+// OpenAPIConfig serves /openapi.json (and the docs UI on /docs) generated from the descriptors
+type OpenAPIConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	DocsUI  bool   `yaml:"docs_ui"`
+	Title   string `yaml:"title"`
+	Version string `yaml:"version"`
+}
+
+// AuthConfig is the cookie auth of browsers (API clients use the Authorization header)
+type AuthConfig struct {
+	RefreshCookiePaths []string `yaml:"refresh_cookie_paths"` // the only paths that get the refresh cookie
+	CSRFCookie         string   `yaml:"csrf_cookie"`
+	CSRFHeader         string   `yaml:"csrf_header"`
+	SecureCookies      bool     `yaml:"secure_cookies"` // required in production for https
+}
+
+// CORSConfig is the cross origin policy of the browsers , route_options can override any field
+type CORSConfig struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins"` // exact , "*" or one wildcard ex: https://*.example.com
+	AllowedMethods   []string      `yaml:"allowed_methods"`
+	AllowedHeaders   []string      `yaml:"allowed_headers"`
+	ExposedHeaders   []string      `yaml:"exposed_headers"` // response headers readable by the frontend JS
+	MaxAge           time.Duration `yaml:"max_age"`         // preflight cache
+	AllowCredentials *bool         `yaml:"allow_credentials"`
 }
 
 type ServerConfig struct {
-	Host          string `yaml:"host"`
-	Port          string `yaml:"port"`
-	PublickeyAddr string `yaml:"public_key_addr"`
+	Host          string        `yaml:"host"`
+	Port          string        `yaml:"port"`
+	PublickeyAddr string        `yaml:"public_key_addr"` // legacy PEM endpoint , used if jwks_url is empty
+	JWKSURL       string        `yaml:"jwks_url"`
+	KeysRefresh   time.Duration `yaml:"keys_refresh_interval"`
+	// CIDRs of the proxies in front of the gateway , their forwarding headers are trusted
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// the one forwarding header the trusted proxies set: x-forwarded-for (default) | forwarded | x-real-ip
+	ClientIPHeader string `yaml:"client_ip_header"`
 }
 
 type RateLimitingConfig struct {
-	RulesConfig         string   `yaml:"rules_config"`
-	ScriptPath          string   `yaml:"script_path"`
-	Addr                []string `yaml:"addrs"`
-	RateLimiterPoolSize int      `yaml:"pool_size"`
-	RateLimitingScript  string
+	RulesConfig         string            `yaml:"rules_config"`
+	ScriptPaths         map[string]string `yaml:"scripts"` // algorithm -> script path
+	Addr                []string          `yaml:"addrs"`
+	RateLimiterPoolSize int               `yaml:"pool_size"`
+	RateLimitingScripts map[string]string // algorithm -> script
+
+	// Degraded mode when the redis cluster is unavailable
+	FailureMode             string        `yaml:"failure_mode"`     // local (default) | open
+	GatewayReplicas         int           `yaml:"gateway_replicas"` // used to split limits between replicas in local mode
+	BreakerFailureThreshold int           `yaml:"breaker_failure_threshold"`
+	BreakerOpenTimeout      time.Duration `yaml:"breaker_open_timeout"`
+}
+
+// BackendConfig is a k8s_services entry , the address alone (ex: post_service: "post-service:50061")
+// or a mapping with the address and the backend options
+type BackendConfig struct {
+	Addr        string            `yaml:"addr"`     // static: host:port list resolved by DNS , dns-srv: the SRV name
+	Registry    string            `yaml:"registry"` // overrides service_registery.type
+	Balancer    string            `yaml:"balancer"` // round_robin (default) | least_request
+	HealthCheck HealthCheckConfig `yaml:"health_check"`
+	Breaker     BreakerConfig     `yaml:"breaker"`
+}
+
+// HealthCheckConfig watches every backend instance over grpc.health.v1 ,
+// the instances that are not SERVING get no calls
+type HealthCheckConfig struct {
+	Disabled bool   `yaml:"disabled"`
+	Service  string `yaml:"service"` // the health service name , "" is the whole server
+}
+
+func (b *BackendConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&b.Addr)
+	}
+	type plain BackendConfig
+	return value.Decode((*plain)(b))
+}
+
+// BreakerConfig trips the circuit breaker of a backend on its error rate or its slow calls rate
+type BreakerConfig struct {
+	Disabled     bool          `yaml:"disabled"`
+	PerMethod    bool          `yaml:"per_method"`     // a breaker per gRPC method instead of one per backend
+	Window       time.Duration `yaml:"window"`         // the rates are computed over this rolling window
+	MinRequests  int           `yaml:"min_requests"`   // no trip under this number of calls in the window
+	ErrorRate    float64       `yaml:"error_rate"`     // 0..1 , of the calls failed by the backend (ex: Unavailable , Internal)
+	SlowCall     time.Duration `yaml:"slow_call"`      // calls slower than this are slow , 0 disables the latency trip
+	SlowCallRate float64       `yaml:"slow_call_rate"` // 0..1
+	OpenTimeout  time.Duration `yaml:"open_timeout"`   // before a probe call is let through
 }
 
 type ServiceConfig struct {
@@ -41,25 +193,61 @@ type RedisConfig struct {
 	CheckScript     string
 }
 
+// RegisteryConfig finds the instances of the k8s_services entries (their registry can override the type)
 type RegisteryConfig struct {
-	ServiceRegisteryPath   string `yaml:"service_registery_path"`
-	ServiceRegisteryPrefix string `yaml:"service_registery_prefix"`
+	Type                   string        `yaml:"type"`                     // static (default) | dns-srv | etcd
+	ServiceRegisteryPath   string        `yaml:"service_registery_path"`   // etcd endpoints , comma separated
+	ServiceRegisteryPrefix string        `yaml:"service_registery_prefix"` // etcd keys are <prefix><service>/<instance id>
+	RefreshInterval        time.Duration `yaml:"refresh_interval"`         // of the static and dns-srv lookups
 }
 
 type RouteOption struct {
-	RequireAuth      bool `yaml:"require_auth"`
-	RateLimitEnabled bool `yaml:"rate_limit_enabled"`
+	RequireAuth      bool               `yaml:"require_auth"`
+	RateLimitEnabled bool               `yaml:"rate_limit_enabled"`
+	Middlewares      []MiddlewareConfig `yaml:"middlewares"` // replaces the two flags when set
+	CORS             *CORSConfig        `yaml:"cors"`        // merged over the global cors section
+	Timeout          time.Duration      `yaml:"timeout"`     // overrides calls.timeout
+	Idempotent       *bool              `yaml:"idempotent"`  // default: GET routes , the only ones retried or hedged
+	Retry            *RetryConfig       `yaml:"retry"`       // set fields override calls.retry
+	Hedge            *HedgeConfig       `yaml:"hedge"`       // replaces the retries
+	Cache            *CacheConfig       `yaml:"cache"`       // GET routes only
+	Invalidates      []string           `yaml:"invalidates"` // paths of the cached routes emptied by a success of this route
+	Shareable        bool               `yaml:"shareable"`   // identical in-flight GET requests share one backend call
+}
+
+// CacheConfig caches the responses of a GET route in redis ,
+// keyed by the route , its path params , its query and the user with per_user
+type CacheConfig struct {
+	TTL     time.Duration `yaml:"ttl"`
+	PerUser bool          `yaml:"per_user"` // for the responses that depend on the user
+}
+
+// MiddlewareConfig is one step of a route pipeline , ex: {name: revoke-token, params: {ttl: 15m}}
+type MiddlewareConfig struct {
+	Name   string            `yaml:"name"`
+	Params map[string]string `yaml:"params"`
 }
 
 type RouteConfig struct {
 	Path             string
 	Method           string
-	Body             string
+	Body             string // "" , "*" or the request field mapped to the body
+	ResponseBody     string // response field mapped to the body ("" for the whole response)
+	ServerStreaming  bool   // served as SSE or NDJSON
+	WebSocket        bool   // client/bidi streaming , bridged over a WebSocket
 	GRPCService      string
 	GRPCMethod       string
 	BackendService   string
 	RequireAuth      bool
 	RateLimitEnabled bool
+	RateRules        []string // names of the rate limit rules bound to this route
+	Timeout          time.Duration
+	Idempotent       bool
+	Retry            RetryConfig
+	Hedge            *HedgeConfig
+	Cache            *CacheConfig
+	Invalidates      []string
+	Shareable        bool
 }
 
 type User struct {
@@ -0,0 +1,411 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	swaggerFiles "github.com/swaggo/files/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+//go:embed docs.html
+var docsPage []byte
+
+// gatewayFields are set by the gateway (see injectedFields) , so they are not documented as params
+var gatewayFields = map[string]bool{"UserId": true, "accessToken": true, "refreshToken": true}
+
+// schema is the subset of the OpenAPI 3 schema object we need
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+}
+
+type parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *schema `json:"schema"`
+}
+
+type mediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type response struct {
+	Description string               `json:"description"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags"`
+	Parameters  []parameter           `json:"parameters,omitempty"`
+	RequestBody *requestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	WebSocket   bool                  `json:"x-websocket,omitempty"`
+}
+
+// openAPIBuilder collects the message schemas while walking the routes
+type openAPIBuilder struct {
+	invoker      *GRPCInvoker
+	schemas      map[string]*schema
+	operationIDs map[string]int
+}
+
+// buildOpenAPI generates the OpenAPI 3 spec of the routes from their descriptors and http annotations
+func buildOpenAPI(invoker *GRPCInvoker, routes map[string]map[string]*models.RouteConfig, cfg models.OpenAPIConfig) ([]byte, error) {
+	b := &openAPIBuilder{invoker: invoker, schemas: make(map[string]*schema), operationIDs: make(map[string]int)}
+	b.schemas["google.rpc.Status"] = &schema{
+		Type: "object",
+		Properties: map[string]*schema{
+			"code":    {Type: "integer", Format: "int32"},
+			"message": {Type: "string"},
+			"details": {Type: "array", Items: &schema{Type: "object", AdditionalProperties: &schema{}}},
+		},
+	}
+
+	// sorted so the operation ids are the same after a reload
+	var sorted []*models.RouteConfig
+	for _, byPath := range routes {
+		for _, route := range byPath {
+			sorted = append(sorted, route)
+		}
+	}
+	slices.SortFunc(sorted, func(a, b *models.RouteConfig) int {
+		if c := strings.Compare(a.Path, b.Path); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Method, b.Method)
+	})
+
+	paths := make(map[string]map[string]*operation)
+	for _, route := range sorted {
+		op, err := b.operation(route)
+		if err != nil {
+			return nil, fmt.Errorf("route %s %s: %w", route.Method, route.Path, err)
+		}
+		path := openAPIPath(route.Path)
+		if paths[path] == nil {
+			paths[path] = make(map[string]*operation)
+		}
+		paths[path][strings.ToLower(route.Method)] = op
+	}
+
+	title := cfg.Title
+	if title == "" {
+		title = "API Gateway"
+	}
+	version := cfg.Version
+	if version == "" {
+		version = "1.0.0"
+	}
+	spec := map[string]any{
+		"openapi": "3.0.3",
+		"info":    map[string]string{"title": title, "version": version},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": b.schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]string{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+		},
+	}
+	return json.MarshalIndent(spec, "", "  ")
+}
+
+func (b *openAPIBuilder) operation(route *models.RouteConfig) (*operation, error) {
+	md, err := b.invoker.method(route.GRPCService, route.GRPCMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	op := &operation{
+		OperationID: b.operationID(route),
+		Summary:     fmt.Sprintf("%s.%s", route.GRPCService, route.GRPCMethod),
+		Tags:        []string{route.GRPCService},
+		Responses: map[string]response{
+			"default": {
+				Description: "Error",
+				Content:     map[string]mediaType{"application/json": {Schema: refSchema("google.rpc.Status")}},
+			},
+		},
+		WebSocket: route.WebSocket,
+	}
+	if route.RequireAuth {
+		op.Security = []map[string][]string{{"bearerAuth": {}}}
+	}
+
+	// path params
+	tmpl, err := parsePathTemplate(route.Path)
+	if err != nil {
+		return nil, err
+	}
+	bound := make(map[string]bool)
+	for _, v := range tmpl.variables {
+		bound[v.fieldPath] = true
+		op.Parameters = append(op.Parameters, parameter{
+			Name:     v.fieldPath,
+			In:       "path",
+			Required: true,
+			Schema:   b.fieldPathSchema(md.inputDescriptor, v.fieldPath),
+		})
+	}
+
+	// request body
+	switch route.Body {
+	case "":
+	case "*":
+		op.RequestBody = &requestBody{Required: true, Content: map[string]mediaType{"application/json": {Schema: b.messageSchema(md.inputDescriptor)}}}
+	default:
+		bound[route.Body] = true
+		op.RequestBody = &requestBody{Required: true, Content: map[string]mediaType{"application/json": {Schema: b.fieldPathSchema(md.inputDescriptor, route.Body)}}}
+	}
+
+	// query params (the top level fields that are not bound)
+	if route.Body != "*" {
+		fields := md.inputDescriptor.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			fd := fields.Get(i)
+			name := string(fd.Name())
+			if bound[name] || gatewayFields[name] || gatewayFields[fd.JSONName()] || fd.IsMap() {
+				continue
+			}
+			if fd.Kind() == protoreflect.MessageKind && !isScalarWellKnown(fd.Message()) {
+				continue
+			}
+			op.Parameters = append(op.Parameters, parameter{Name: name, In: "query", Schema: b.fieldSchema(fd)})
+		}
+	}
+
+	// response
+	respSchema := b.messageSchema(md.outputDescriptor)
+	if route.ResponseBody != "" {
+		respSchema = b.fieldPathSchema(md.outputDescriptor, route.ResponseBody)
+	}
+	switch {
+	case route.WebSocket:
+		op.Description = "WebSocket: every JSON text frame is a request message , every response is sent back as a JSON text frame"
+		op.Responses["101"] = response{Description: "Switching Protocols", Content: map[string]mediaType{"application/json": {Schema: respSchema}}}
+	case route.ServerStreaming:
+		op.Description = "Server stream: one event (SSE) or line (NDJSON) per message"
+		op.Responses["200"] = response{Description: "OK", Content: map[string]mediaType{
+			contentTypeSSE:    {Schema: respSchema},
+			contentTypeNDJSON: {Schema: respSchema},
+		}}
+	default:
+		op.Responses["200"] = response{Description: "OK", Content: map[string]mediaType{"application/json": {Schema: respSchema}}}
+	}
+	return op, nil
+}
+
+// messageSchema registers the message (and the messages it uses) in the components
+func (b *openAPIBuilder) messageSchema(desc protoreflect.MessageDescriptor) *schema {
+	if s := wellKnownSchema(desc); s != nil {
+		return s
+	}
+	name := string(desc.FullName())
+	if _, ok := b.schemas[name]; ok {
+		return refSchema(name)
+	}
+	s := &schema{Type: "object", Properties: make(map[string]*schema)}
+	// registered before the fields so recursive messages stop here
+	b.schemas[name] = s
+	fields := desc.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		s.Properties[string(fd.Name())] = b.fieldSchema(fd)
+	}
+	return refSchema(name)
+}
+
+func (b *openAPIBuilder) fieldSchema(fd protoreflect.FieldDescriptor) *schema {
+	if fd.IsMap() {
+		return &schema{Type: "object", AdditionalProperties: b.singularSchema(fd.MapValue())}
+	}
+	if fd.IsList() {
+		return &schema{Type: "array", Items: b.singularSchema(fd)}
+	}
+	return b.singularSchema(fd)
+}
+
+// singularSchema follows the protojson mapping (ex: 64 bits integers are strings)
+func (b *openAPIBuilder) singularSchema(fd protoreflect.FieldDescriptor) *schema {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return &schema{Type: "boolean"}
+	case protoreflect.StringKind:
+		return &schema{Type: "string"}
+	case protoreflect.BytesKind:
+		return &schema{Type: "string", Format: "byte"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return &schema{Type: "integer", Format: "int32"}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return &schema{Type: "integer", Format: "int64"}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return &schema{Type: "string", Format: "int64"}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return &schema{Type: "string", Format: "uint64"}
+	case protoreflect.FloatKind:
+		return &schema{Type: "number", Format: "float"}
+	case protoreflect.DoubleKind:
+		return &schema{Type: "number", Format: "double"}
+	case protoreflect.EnumKind:
+		values := fd.Enum().Values()
+		s := &schema{Type: "string"}
+		for i := 0; i < values.Len(); i++ {
+			s.Enum = append(s.Enum, string(values.Get(i).Name()))
+		}
+		return s
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return b.messageSchema(fd.Message())
+	}
+	return &schema{}
+}
+
+// fieldPathSchema is the schema of a (nested) field like "post.id"
+func (b *openAPIBuilder) fieldPathSchema(desc protoreflect.MessageDescriptor, fieldPath string) *schema {
+	names := strings.Split(fieldPath, ".")
+	for i, name := range names {
+		fd := findField(desc, name)
+		if fd == nil {
+			return &schema{Type: "string"}
+		}
+		if i == len(names)-1 {
+			return b.fieldSchema(fd)
+		}
+		if fd.Kind() != protoreflect.MessageKind {
+			return &schema{Type: "string"}
+		}
+		desc = fd.Message()
+	}
+	return &schema{Type: "string"}
+}
+
+// wellKnownSchema maps the well known types to their JSON form
+func wellKnownSchema(desc protoreflect.MessageDescriptor) *schema {
+	switch desc.FullName() {
+	case "google.protobuf.Timestamp":
+		return &schema{Type: "string", Format: "date-time"}
+	case "google.protobuf.Duration", "google.protobuf.FieldMask":
+		return &schema{Type: "string"}
+	case "google.protobuf.StringValue":
+		return &schema{Type: "string"}
+	case "google.protobuf.BytesValue":
+		return &schema{Type: "string", Format: "byte"}
+	case "google.protobuf.BoolValue":
+		return &schema{Type: "boolean"}
+	case "google.protobuf.Int32Value", "google.protobuf.UInt32Value":
+		return &schema{Type: "integer"}
+	case "google.protobuf.Int64Value", "google.protobuf.UInt64Value":
+		return &schema{Type: "string", Format: "int64"}
+	case "google.protobuf.FloatValue", "google.protobuf.DoubleValue":
+		return &schema{Type: "number"}
+	case "google.protobuf.Struct", "google.protobuf.Any", "google.protobuf.Empty":
+		return &schema{Type: "object", AdditionalProperties: &schema{}}
+	case "google.protobuf.Value", "google.protobuf.ListValue":
+		return &schema{}
+	}
+	return nil
+}
+
+// isScalarWellKnown reports the message types that can be set from a query param
+func isScalarWellKnown(desc protoreflect.MessageDescriptor) bool {
+	s := wellKnownSchema(desc)
+	return s != nil && s.Type != "object" && s.Type != ""
+}
+
+func refSchema(name string) *schema {
+	return &schema{Ref: "#/components/schemas/" + name}
+}
+
+// openAPIPath converts a path template to an OpenAPI path
+// ex: /v1/{name=shelves/*}:publish -> /v1/{name}:publish
+func openAPIPath(path string) string {
+	var sb strings.Builder
+	inVariable, skipping := false, false
+	for _, c := range path {
+		switch {
+		case c == '{':
+			inVariable = true
+		case c == '}':
+			inVariable, skipping = false, false
+		case c == '=' && inVariable:
+			skipping = true
+		}
+		if !skipping {
+			sb.WriteRune(c)
+		}
+	}
+	return sb.String()
+}
+
+// operationID is Service_Method , additional bindings get a number to stay unique
+func (b *openAPIBuilder) operationID(route *models.RouteConfig) string {
+	service := route.GRPCService
+	if i := strings.LastIndex(service, "."); i >= 0 {
+		service = service[i+1:]
+	}
+	id := fmt.Sprintf("%s_%s", service, route.GRPCMethod)
+	b.operationIDs[id]++
+	if n := b.operationIDs[id]; n > 1 {
+		id = fmt.Sprintf("%s%d", id, n)
+	}
+	return id
+}
+
+// OpenAPIHandler serves the spec of the current routes
+func (h *Handler) OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	st := h.state.Load()
+	if !st.config.OpenAPI.Enabled || st.openapi == nil {
+		writeError(w, codes.NotFound, "ROUTE_NOT_FOUND", "Route not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(st.openapi)
+}
+
+// DocsHandler serves the docs UI of /openapi.json
+func (h *Handler) DocsHandler(w http.ResponseWriter, r *http.Request) {
+	st := h.state.Load()
+	if !st.config.OpenAPI.Enabled || !st.config.OpenAPI.DocsUI {
+		writeError(w, codes.NotFound, "ROUTE_NOT_FOUND", "Route not found")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(docsPage)
+}
+
+// docsAssets are the Swagger UI files served with the docs page (embedded , no third party script is loaded)
+var docsAssets = map[string]bool{"swagger-ui.css": true, "swagger-ui-bundle.js": true}
+
+// DocsAssetHandler serves the embedded Swagger UI files of the docs page
+func (h *Handler) DocsAssetHandler(w http.ResponseWriter, r *http.Request) {
+	st := h.state.Load()
+	name := r.PathValue("file")
+	if !st.config.OpenAPI.Enabled || !st.config.OpenAPI.DocsUI || !docsAssets[name] {
+		writeError(w, codes.NotFound, "ROUTE_NOT_FOUND", "Route not found")
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeFileFS(w, r, swaggerFiles.FS, name)
+}
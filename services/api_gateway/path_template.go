@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Path templates follow the google.api.http syntax:
+//
+//	Template = "/" Segments [ Verb ] ;
+//	Segments = Segment { "/" Segment } ;
+//	Segment  = "*" | "**" | LITERAL | Variable ;
+//	Variable = "{" FieldPath [ "=" Segments ] "}" ;
+//	Verb     = ":" LITERAL ;
+//
+// e.g /api/v1/posts/{post.id} , /v1/{name=shelves/*/books/**}:publish
+
+type segmentKind int
+
+const (
+	segLiteral      segmentKind = iota
+	segWildcard                 // * matches one segment
+	segDeepWildcard             // ** matches the rest of the path
+)
+
+type templateSegment struct {
+	kind    segmentKind
+	literal string
+}
+
+// templateVariable binds the segments [start,end) to a request field path
+type templateVariable struct {
+	fieldPath  string
+	start, end int
+}
+
+type pathTemplate struct {
+	segments  []templateSegment
+	variables []templateVariable
+	verb      string
+}
+
+func parsePathTemplate(path string) (*pathTemplate, error) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("path template %q must start with /", path)
+	}
+	if path == "/" {
+		return &pathTemplate{}, nil
+	}
+	rawSegments, verb, err := splitTemplate(path[1:])
+	if err != nil {
+		return nil, fmt.Errorf("path template %q: %w", path, err)
+	}
+
+	tmpl := &pathTemplate{verb: verb}
+	for _, raw := range rawSegments {
+		if !strings.HasPrefix(raw, "{") {
+			tmpl.segments = append(tmpl.segments, parseSegment(raw))
+			continue
+		}
+		fieldPath, pattern, _ := strings.Cut(raw[1:len(raw)-1], "=")
+		if fieldPath == "" {
+			return nil, fmt.Errorf("path template %q: empty variable name", path)
+		}
+		if pattern == "" {
+			pattern = "*"
+		}
+		start := len(tmpl.segments)
+		for _, sub := range strings.Split(pattern, "/") {
+			if strings.ContainsAny(sub, "{}") {
+				return nil, fmt.Errorf("path template %q: nested variables are not allowed", path)
+			}
+			tmpl.segments = append(tmpl.segments, parseSegment(sub))
+		}
+		tmpl.variables = append(tmpl.variables, templateVariable{
+			fieldPath: fieldPath,
+			start:     start,
+			end:       len(tmpl.segments),
+		})
+	}
+
+	for i, seg := range tmpl.segments {
+		if seg.kind == segDeepWildcard && i != len(tmpl.segments)-1 {
+			return nil, fmt.Errorf("path template %q: ** must be the last segment", path)
+		}
+		if seg.kind == segLiteral && seg.literal == "" && len(tmpl.segments) > 1 {
+			return nil, fmt.Errorf("path template %q: empty segment", path)
+		}
+	}
+	return tmpl, nil
+}
+
+// splitTemplate splits on the "/" outside variables and cuts the verb
+func splitTemplate(path string) ([]string, string, error) {
+	var segments []string
+	depth, start := 0, 0
+	verb := ""
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '{':
+			depth++
+			if depth > 1 {
+				return nil, "", fmt.Errorf("nested variables are not allowed")
+			}
+		case '}':
+			depth--
+			if depth < 0 {
+				return nil, "", fmt.Errorf("unbalanced braces")
+			}
+		case '/':
+			if depth == 0 {
+				segments = append(segments, path[start:i])
+				start = i + 1
+			}
+		case ':':
+			// a verb can only be at the end of the last segment
+			if depth == 0 && !strings.Contains(path[i:], "/") {
+				verb = path[i+1:]
+				segments = append(segments, path[start:i])
+				return segments, verb, nil
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, "", fmt.Errorf("unbalanced braces")
+	}
+	segments = append(segments, path[start:])
+	return segments, verb, nil
+}
+
+func parseSegment(raw string) templateSegment {
+	switch raw {
+	case "*":
+		return templateSegment{kind: segWildcard}
+	case "**":
+		return templateSegment{kind: segDeepWildcard}
+	}
+	return templateSegment{kind: segLiteral, literal: raw}
+}
+
+// bind maps the matched segment values to the template variables
+func (t *pathTemplate) bind(values []string) map[string]string {
+	params := make(map[string]string, len(t.variables))
+	for _, v := range t.variables {
+		end := min(v.end, len(values))
+		params[v.fieldPath] = strings.Join(values[v.start:end], "/")
+	}
+	return params
+}
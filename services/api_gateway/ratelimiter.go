@@ -3,9 +3,14 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
@@ -19,16 +24,75 @@ import (
 
 type RateLimiter struct {
 	ctx          context.Context
-	rules        map[string]Rule
+	cancel       context.CancelFunc
 	redisCluster *redis.ClusterClient
-	script       string //lua script to run redis commands
+	failureMode  string
+	breaker      *CircuitBreaker // tracks the redis cluster health
+	local        *LocalLimiter   // used while the breaker is open (failureMode = local)
 }
 
 type KeyExtractor func(r *http.Request) string
 
+// RuleSet is the compiled content of the rules config and the lua scripts.
+// It is immutable, so a reload builds a new one and swaps it with the routes.
+type RuleSet struct {
+	rules       map[string]Rule
+	globalRules []string          // rules applied to every authenticated user
+	scripts     map[string]string // algorithm -> lua script to run redis commands
+}
+
+// name of the rule used by AllowIP
+const ipRule = "IP"
+
+// What to do when the redis cluster is unavailable
+const (
+	FailureModeLocal = "local" // fallback to in-process token buckets (default)
+	FailureModeOpen  = "open"  // allow every request
+)
+
+// Supported rate limiting algorithms, every one has its own lua script
+const (
+	TokenBucket          = "token_bucket"
+	SlidingWindowLog     = "sliding_window_log"
+	SlidingWindowCounter = "sliding_window_counter"
+	GCRA                 = "gcra"
+	FixedWindow          = "fixed_window"
+)
+
 type Rule struct {
-	Limit      int `json:"limit"`      // bucket size
-	RefillRate int `json:"refillRate"` // requests/s
+	Algorithm  string `json:"algorithm"`  // default is token_bucket
+	Limit      int    `json:"limit"`      // bucket size / burst / requests per window
+	RefillRate int    `json:"refillRate"` // requests/s (token_bucket , gcra)
+	WindowMs   int    `json:"windowMs"`   // window size in ms (windows algorithms)
+}
+
+// args returns the lua script ARGV of the rule algorithm
+func (r Rule) args() []interface{} {
+	switch r.Algorithm {
+	case SlidingWindowLog, SlidingWindowCounter, FixedWindow:
+		return []interface{}{r.Limit, r.WindowMs}
+	default: // token_bucket , gcra
+		return []interface{}{r.RefillRate, r.Limit}
+	}
+}
+
+func (r Rule) validate() error {
+	if r.Limit <= 0 {
+		return errors.New("limit must be positive")
+	}
+	switch r.Algorithm {
+	case TokenBucket, GCRA:
+		if r.RefillRate <= 0 {
+			return fmt.Errorf("%s needs a positive refillRate", r.Algorithm)
+		}
+	case SlidingWindowLog, SlidingWindowCounter, FixedWindow:
+		if r.WindowMs <= 0 {
+			return fmt.Errorf("%s needs a positive windowMs", r.Algorithm)
+		}
+	default:
+		return fmt.Errorf("unknown algorithm %q", r.Algorithm)
+	}
+	return nil
 }
 
 type RateLimitInfo struct {
@@ -39,47 +103,123 @@ type RateLimitInfo struct {
 }
 
 func NewRateLimiter(config models.RateLimitingConfig) (*RateLimiter, error) {
+	failureMode := config.FailureMode
+	if failureMode == "" {
+		failureMode = FailureModeLocal
+	}
+	if failureMode != FailureModeLocal && failureMode != FailureModeOpen {
+		return nil, fmt.Errorf("unknown rate limiting failure_mode %q", failureMode)
+	}
+
 	c := redis.NewClusterClient(&redis.ClusterOptions{
 		Addrs:    config.Addr,
 		PoolSize: config.RateLimiterPoolSize,
 	})
+	breaker := NewCircuitBreaker("redis_rate_limiter", config.BreakerFailureThreshold, config.BreakerOpenTimeout)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
-	if err := c.Ping(ctx).Err(); err != nil {
+	pingCtx, pingCancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer pingCancel()
+	if err := c.Ping(pingCtx).Err(); err != nil {
 		log.Println("Error in Connection to redis Cluster: ", err)
-		return nil, err
+		if failureMode == FailureModeOpen {
+			c.Close()
+			return nil, err
+		}
+		// start in degraded mode, the breaker will probe redis again later
+		log.Println("Warning: rate limiter starts with the local fallback limiter")
+		breaker.Trip()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rl := &RateLimiter{
+		ctx:          ctx,
+		cancel:       cancel,
+		redisCluster: c,
+		failureMode:  failureMode,
+		breaker:      breaker,
 	}
+	if failureMode == FailureModeLocal {
+		rl.local = NewLocalLimiter(ctx, config.GatewayReplicas)
+	}
+	return rl, nil
+}
+
+// LoadRuleSet loads and validates the rules config
+func LoadRuleSet(config models.RateLimitingConfig) (*RuleSet, error) {
 	rules, err := loadRules(config.RulesConfig)
 	if err != nil {
 		return nil, err
 	}
-	ctx = context.Background()
-	return &RateLimiter{ctx: ctx, rules: rules, redisCluster: c, script: config.RateLimitingScript}, nil
+	for name, rule := range rules {
+		if _, ok := config.RateLimitingScripts[rule.Algorithm]; !ok {
+			return nil, fmt.Errorf("no script loaded for algorithm %s of rule %s", rule.Algorithm, name)
+		}
+	}
+	var globalRules []string
+	for name := range rules {
+		if name == ipRule {
+			continue
+		}
+		if _, _, ok := parseRouteRule(name); !ok {
+			globalRules = append(globalRules, name)
+		}
+	}
+	slices.Sort(globalRules)
+
+	return &RuleSet{rules: rules, globalRules: globalRules, scripts: config.RateLimitingScripts}, nil
 }
 
-func (rl *RateLimiter) AllowIP(r *http.Request) (*RateLimitInfo, error) {
+// BindRoutes attaches every route rule ("METHOD /path/{param}") to
+// its route in the trie, so a request is checked only against its own rules
+func (rs *RuleSet) BindRoutes(router *Router) {
+	for name := range rs.rules {
+		method, pattern, ok := parseRouteRule(name)
+		if !ok {
+			continue
+		}
+		route := router.Lookup(method, pattern)
+		if route == nil {
+			log.Printf("Warning: rate limit rule %q matches no route", name)
+			continue
+		}
+		route.RateRules = append(route.RateRules, name)
+		slices.Sort(route.RateRules)
+		log.Printf("Rate limit rule %q bound to %s %s", name, route.Method, route.Path)
+	}
+}
+
+func (rl *RateLimiter) AllowIP(r *http.Request, rs *RuleSet) (*RateLimitInfo, error) {
+	rule, ok := rs.rules[ipRule]
+	if !ok {
+		return &RateLimitInfo{Allowed: true}, nil
+	}
 	id := ipExtractor(r)
 	// log.Println("IP ID", id)
-	return rl.Allow(id, rl.rules["IP"])
+	info, err := rl.Allow(id, rule, rs)
+	if info != nil {
+		rateLimitDecisions.WithLabelValues(ipRule, decision(info.Allowed)).Inc()
+	}
+	return info, err
 }
 
-func (rl *RateLimiter) AllowRules(r *http.Request) (*RateLimitInfo, error) {
-	userID := userIdExtractor(r)
+// AllowRules applies the rules bound to the matched route.
+// Authenticated users are also checked against the global rules (ex: UserId)
+// and are limited by their ID, anonymous ones by their IP
+func (rl *RateLimiter) AllowRules(r *http.Request, rs *RuleSet, route *models.RouteConfig, userID string) (*RateLimitInfo, error) {
+	id := userID
+	ruleNames := route.RateRules
+	if userID != "" {
+		ruleNames = slices.Concat(rs.globalRules, route.RateRules)
+	} else {
+		id = ipExtractor(r)
+	}
 	var mostRestrictive *RateLimitInfo
-	for ruleName, rule := range rl.rules {
-		// if ruleName == "IP" {
-		// 	continue
-		// }
-		// for now i have just two rules (Per IP , per UserID)
-		// More custom rules (ex: per URL) can be added
-		// just add different rules with name as the URL
-		// and we can match them efficiently using data structure like trie
-		// matched := matchRule(r , rule.Name)
-		info, err := rl.Allow(userID+ruleName, rule)
+	for _, ruleName := range ruleNames {
+		info, err := rl.Allow(id+ruleName, rs.rules[ruleName], rs)
 		if err != nil {
 			return info, err
 		}
+		rateLimitDecisions.WithLabelValues(ruleName, decision(info.Allowed)).Inc()
 		if !info.Allowed {
 			return info, nil
 		}
@@ -94,30 +234,53 @@ func (rl *RateLimiter) AllowRules(r *http.Request) (*RateLimitInfo, error) {
 	return mostRestrictive, nil
 }
 
-func (rl *RateLimiter) Allow(id string, rule Rule) (*RateLimitInfo, error) {
+func (rl *RateLimiter) Allow(id string, rule Rule, rs *RuleSet) (*RateLimitInfo, error) {
+
+	// redis is known to be down, don't wait for it
+	ticket, ok := rl.breaker.Allow()
+	if !ok {
+		return rl.fallback(id, rule, errRedisUnavailable)
+	}
 
-	keys := []string{id}
-	args := []interface{}{rule.RefillRate, rule.Limit, time.Now().Unix()}
-	info, err := rl.checkRedis(keys, args)
+	// every algorithm stores a different data type,
+	// so the key is scoped by algorithm to avoid WRONGTYPE errors
+	keys := []string{rule.Algorithm + ":" + id}
+	info, err := rl.checkRedis(rs.scripts[rule.Algorithm], keys, rule.args())
 	// log.Printf("REDIS RL: %v %v", info, err)
 	if err != nil {
-		return info, err
+		rl.breaker.Failure(ticket)
+		return rl.fallback(id, rule, err)
 	}
+	rl.breaker.Success(ticket)
 
 	return info, nil
 }
 
+var errRedisUnavailable = errors.New("rate limiter redis cluster is unavailable")
+
+// fallback is used when redis can`t be reached.
+// In open mode the request is allowed and the error returned (caller fails open)
+// otherwise the local limiter decides
+func (rl *RateLimiter) fallback(id string, rule Rule, err error) (*RateLimitInfo, error) {
+	rateLimitFallbacks.WithLabelValues(rl.failureMode).Inc()
+	if rl.failureMode == FailureModeOpen {
+		return &RateLimitInfo{Allowed: true}, err
+	}
+	return rl.local.Allow(rule.Algorithm+":"+id, rule), nil
+}
+
 // Check redis atomically using lua script
-func (rl *RateLimiter) checkRedis(keys []string, args []interface{}) (*RateLimitInfo, error) {
+func (rl *RateLimiter) checkRedis(script string, keys []string, args []interface{}) (*RateLimitInfo, error) {
 
-	res, err := rl.redisCluster.Eval(rl.ctx, rl.script, keys, args).Result()
+	res, err := rl.redisCluster.Eval(rl.ctx, script, keys, args).Result()
 
-	log.Println("REDIS RESULT", res)
+	slog.Debug("rate limit script result", "result", res)
 
 	// Now it is a trade off
-	// lets fail-Open
+	// the caller decides to fail-Open or fallback to local limiter
 	if err != nil {
-		log.Printf("There is error in redis connection: %v", err.Error())
+		slog.Error("error in redis connection", "error", err)
+		redisErrors.WithLabelValues(redisRateLimiter).Inc()
 		return &RateLimitInfo{Allowed: true}, err
 	}
 
@@ -162,12 +325,18 @@ func (rl *RateLimiter) checkRedis(keys []string, args []interface{}) (*RateLimit
 	return &RateLimitInfo{Allowed: true}, nil
 }
 
+// ipExtractor keys anonymous requests by the resolved client ip , not the peer address (its port changes per connection)
 func ipExtractor(r *http.Request) string {
-	return r.RemoteAddr
+	return clientIP(r)
 }
 
-func userIdExtractor(r *http.Request) string {
-	return r.Header.Get("UserId")
+// Rules named "METHOD /path" are route rules, e.g "POST /api/v1/posts/post"
+func parseRouteRule(name string) (method, pattern string, ok bool) {
+	method, pattern, ok = strings.Cut(name, " ")
+	if !ok || !strings.HasPrefix(pattern, "/") {
+		return "", "", false
+	}
+	return method, pattern, true
 }
 
 func loadRules(configPath string) (map[string]Rule, error) {
@@ -180,10 +349,20 @@ func loadRules(configPath string) (map[string]Rule, error) {
 	if err := json.Unmarshal(data, &rules); err != nil {
 		return nil, err
 	}
+	for name, rule := range rules {
+		if rule.Algorithm == "" {
+			rule.Algorithm = TokenBucket
+			rules[name] = rule
+		}
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("invalid rate limit rule %s: %w", name, err)
+		}
+	}
 	return rules, nil
 }
 
 func (r *RateLimiter) close() {
+	r.cancel()
 	if err := r.redisCluster.Close(); err != nil {
 		log.Println("Closing rateLimiter Error: ", err.Error())
 	}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"google.golang.org/grpc"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Descriptor sources of the gateway routes
+const (
+	SourceProtoset   = "protoset"   // pre-built .protoset files (default)
+	SourceReflection = "reflection" // pulled from the backends over gRPC server reflection
+)
+
+// fetchDescriptors pulls all the files that define the backend services
+// (and their imports) using the gRPC server reflection protocol
+func fetchDescriptors(ctx context.Context, conn *grpc.ClientConn) (*descriptorpb.FileDescriptorSet, error) {
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.CloseSend()
+
+	resp, err := reflectionCall(stream, &rpb.ServerReflectionRequest{
+		MessageRequest: &rpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*descriptorpb.FileDescriptorProto)
+	for _, svc := range resp.GetListServicesResponse().GetService() {
+		// no need for the infra services
+		if strings.HasPrefix(svc.GetName(), "grpc.reflection.") || strings.HasPrefix(svc.GetName(), "grpc.health.") {
+			continue
+		}
+		resp, err := reflectionCall(stream, &rpb.ServerReflectionRequest{
+			MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: svc.GetName()},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %w", svc.GetName(), err)
+		}
+		if err := addReflectedFiles(files, resp); err != nil {
+			return nil, err
+		}
+	}
+
+	// servers usually send the imports with the file, ask for any missing one
+	for {
+		missing := missingDependencies(files)
+		if len(missing) == 0 {
+			break
+		}
+		for _, name := range missing {
+			resp, err := reflectionCall(stream, &rpb.ServerReflectionRequest{
+				MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{FileByFilename: name},
+			})
+			if err != nil {
+				// the resolver can still find it if it is linked in the gateway
+				log.Printf("Warning: reflection can`t find %s: %v", name, err)
+				files[name] = nil
+				continue
+			}
+			if err := addReflectedFiles(files, resp); err != nil {
+				return nil, err
+			}
+			// the response may not carry the requested file , don`t ask for it again
+			if _, ok := files[name]; !ok {
+				log.Printf("Warning: reflection did not return %s", name)
+				files[name] = nil
+			}
+		}
+	}
+
+	return &descriptorpb.FileDescriptorSet{File: sortByDependencies(files)}, nil
+}
+
+func reflectionCall(stream rpb.ServerReflection_ServerReflectionInfoClient, req *rpb.ServerReflectionRequest) (*rpb.ServerReflectionResponse, error) {
+	if err := stream.Send(req); err != nil {
+		return nil, err
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if errResp := resp.GetErrorResponse(); errResp != nil {
+		return nil, fmt.Errorf("reflection error %d: %s", errResp.GetErrorCode(), errResp.GetErrorMessage())
+	}
+	return resp, nil
+}
+
+func addReflectedFiles(files map[string]*descriptorpb.FileDescriptorProto, resp *rpb.ServerReflectionResponse) error {
+	for _, raw := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+		// unmarshal with the gateway registry so google.api.http options are typed
+		fd := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(raw, fd); err != nil {
+			return fmt.Errorf("invalid file descriptor: %w", err)
+		}
+		files[fd.GetName()] = fd
+	}
+	return nil
+}
+
+func missingDependencies(files map[string]*descriptorpb.FileDescriptorProto) []string {
+	var missing []string
+	for _, fd := range files {
+		for _, dep := range fd.GetDependency() {
+			if _, ok := files[dep]; !ok && !slices.Contains(missing, dep) {
+				missing = append(missing, dep)
+			}
+		}
+	}
+	return missing
+}
+
+// sortByDependencies orders the files so every file comes after its imports
+func sortByDependencies(files map[string]*descriptorpb.FileDescriptorProto) []*descriptorpb.FileDescriptorProto {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	sorted := make([]*descriptorpb.FileDescriptorProto, 0, len(files))
+	visited := make(map[string]bool, len(files))
+	var visit func(name string)
+	visit = func(name string) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+		fd := files[name]
+		if fd == nil {
+			return
+		}
+		for _, dep := range fd.GetDependency() {
+			visit(dep)
+		}
+		sorted = append(sorted, fd)
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	return sorted
+}
+
+// fetchAllDescriptors gets the descriptors of every backend.
+// A backend that fails keeps its previous descriptors if there are any.
+func fetchAllDescriptors(serviceConns *ServiceConnections, k8sServices map[string]*models.BackendConfig, previous map[string]*descriptorpb.FileDescriptorSet) map[string]*descriptorpb.FileDescriptorSet {
+	sets := make(map[string]*descriptorpb.FileDescriptorSet, len(k8sServices))
+	for serviceName := range k8sServices {
+		conn, err := serviceConns.GetConn(serviceName)
+		if err != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		fds, err := fetchDescriptors(ctx, conn)
+		cancel()
+		if err != nil {
+			log.Printf("Warning: Failed to reflect descriptors of %s: %v", serviceName, err)
+			if prev, ok := previous[serviceName]; ok {
+				sets[serviceName] = prev
+			}
+			continue
+		}
+		sets[serviceName] = fds
+	}
+	return sets
+}
+
+// descriptorsHash is used to detect if the backends changed their descriptors
+func descriptorsHash(sets map[string]*descriptorpb.FileDescriptorSet) string {
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	h := sha256.New()
+	marshaler := proto.MarshalOptions{Deterministic: true}
+	for _, name := range names {
+		data, err := marshaler.Marshal(sets[name])
+		if err != nil {
+			continue
+		}
+		h.Write([]byte(name))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
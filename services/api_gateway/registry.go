@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"google.golang.org/grpc/resolver"
+)
+
+// Types of service registry (service_registery.type or the registry of a k8s_services entry)
+const (
+	RegistryStatic = "static"  // default , the addresses of k8s_services resolved by DNS
+	RegistryDNSSRV = "dns-srv" // the SRV records of the addr (ex: _grpc._tcp.feed-service.dmb.svc.cluster.local)
+	RegistryEtcd   = "etcd"    // the instances registered with a lease by the services
+)
+
+// Registry finds the instances (host:port) of the backends
+type Registry interface {
+	// Watch sends the instances of a k8s_services entry each time they change ,
+	// or the error when they can`t be found. The channel is closed with ctx
+	Watch(ctx context.Context, service string) (<-chan RegistryUpdate, error)
+	Close() error
+}
+
+// RegistryUpdate is the current instances of a backend , or the failure of the registry
+// (the instances sent before are kept)
+type RegistryUpdate struct {
+	Instances []string
+	Err       error
+}
+
+// newRegistries opens the registries used by the k8s_services entries
+func newRegistries(cfg models.RegisteryConfig, services map[string]*models.BackendConfig) (map[string]Registry, error) {
+	registries := make(map[string]Registry)
+	for _, backend := range services {
+		if _, ok := registries[backend.Registry]; ok {
+			continue
+		}
+		var registry Registry
+		switch backend.Registry {
+		case RegistryStatic:
+			registry = &lookupRegistry{services: services, interval: cfg.RefreshInterval, lookup: lookupHosts}
+		case RegistryDNSSRV:
+			registry = &lookupRegistry{services: services, interval: cfg.RefreshInterval, lookup: lookupSRV}
+		case RegistryEtcd:
+			etcd, err := newEtcdRegistry(cfg)
+			if err != nil {
+				closeRegistries(registries)
+				return nil, err
+			}
+			registry = etcd
+		default:
+			closeRegistries(registries)
+			return nil, fmt.Errorf("unknown registry %q", backend.Registry)
+		}
+		registries[backend.Registry] = registry
+	}
+	return registries, nil
+}
+
+func closeRegistries(registries map[string]Registry) {
+	for name, registry := range registries {
+		if err := registry.Close(); err != nil {
+			slog.Error("error closing the registry", "registry", name, "error", err)
+		}
+	}
+}
+
+// lookupRegistry polls DNS every interval for the instances of an addr
+type lookupRegistry struct {
+	services map[string]*models.BackendConfig
+	interval time.Duration
+	lookup   func(ctx context.Context, addr string) ([]string, error)
+}
+
+func (r *lookupRegistry) Watch(ctx context.Context, service string) (<-chan RegistryUpdate, error) {
+	backend, ok := r.services[service]
+	if !ok {
+		return nil, fmt.Errorf("unknown service %s", service)
+	}
+	updates := make(chan RegistryUpdate, 1)
+	go func() {
+		defer close(updates)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		var current []string
+		sent := false // an empty first result is sent too
+		for {
+			instances, err := r.lookup(ctx, backend.Addr)
+			var update *RegistryUpdate
+			switch {
+			case err != nil:
+				// the known instances are kept
+				slog.Warn("failed to look up the instances", "service", service, "addr", backend.Addr, "error", err)
+				update = &RegistryUpdate{Err: err}
+			case !sent || !slices.Equal(instances, current):
+				current, sent = instances, true
+				update = &RegistryUpdate{Instances: instances}
+			}
+			if update != nil {
+				select {
+				case updates <- *update:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return updates, nil
+}
+
+func (r *lookupRegistry) Close() error { return nil }
+
+// lookupHosts resolves every host of a comma separated host:port list ,
+// a headless service (or a scaled compose service) gives all its IPs
+func lookupHosts(ctx context.Context, addrs string) ([]string, error) {
+	var instances []string
+	for _, addr := range strings.Split(addrs, ",") {
+		host, port, err := net.SplitHostPort(strings.TrimSpace(addr))
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) != nil {
+			instances = append(instances, net.JoinHostPort(host, port))
+			continue
+		}
+		ips, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			instances = append(instances, net.JoinHostPort(ip, port))
+		}
+	}
+	slices.Sort(instances)
+	return slices.Compact(instances), nil
+}
+
+// lookupSRV gives the targets of the SRV records of name
+func lookupSRV(ctx context.Context, name string) ([]string, error) {
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, err
+	}
+	instances := make([]string, 0, len(records))
+	for _, srv := range records {
+		instances = append(instances, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), fmt.Sprint(srv.Port)))
+	}
+	slices.Sort(instances)
+	return slices.Compact(instances), nil
+}
+
+// registryScheme is the gRPC target scheme of the backends , ex: registry:///feed_service
+const registryScheme = "registry"
+
+// registryResolver gives the instances of a backend to its gRPC connection (balanced over them)
+type registryResolver struct {
+	registry Registry
+}
+
+func (b *registryResolver) Scheme() string { return registryScheme }
+
+func (b *registryResolver) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	service := target.Endpoint()
+	ctx, cancel := context.WithCancel(context.Background())
+	updates, err := b.registry.Watch(ctx, service)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go func() {
+		for update := range updates {
+			if update.Err != nil {
+				// the calls fail fast with Unavailable instead of waiting for a first state
+				cc.ReportError(update.Err)
+				continue
+			}
+			instances := update.Instances
+			backendInstances.WithLabelValues(service).Set(float64(len(instances)))
+			slog.Info("backend instances changed", "service", service, "instances", instances)
+			state := resolver.State{Addresses: make([]resolver.Address, 0, len(instances))}
+			for _, addr := range instances {
+				state.Addresses = append(state.Addresses, resolver.Address{Addr: addr})
+			}
+			// an empty state is pushed too , the deregistered instances get no more calls
+			// (the balancer rejects it but drops the old instances , the calls fail fast)
+			if err := cc.UpdateState(state); err != nil && len(instances) > 0 {
+				slog.Warn("instances rejected by the balancer", "service", service, "error", err)
+			}
+		}
+	}()
+	return &watchResolver{cancel: cancel}, nil
+}
+
+type watchResolver struct {
+	cancel context.CancelFunc
+}
+
+// ResolveNow is a no-op , the registries push the changes (or poll)
+func (r *watchResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *watchResolver) Close() { r.cancel() }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	etcd "go.etcd.io/etcd/client/v3"
+)
+
+// etcdRegistry watches the instances registered by the services under <prefix><service>/<instance id>
+// (the value is the instance host:port). The keys have a lease , a dead instance is removed once it expires.
+type etcdRegistry struct {
+	client *etcd.Client
+	prefix string
+}
+
+func newEtcdRegistry(cfg models.RegisteryConfig) (*etcdRegistry, error) {
+	var endpoints []string
+	for _, endpoint := range strings.Split(cfg.ServiceRegisteryPath, ",") {
+		if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	client, err := etcd.New(etcd.Config{Endpoints: endpoints, DialTimeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &etcdRegistry{client: client, prefix: cfg.ServiceRegisteryPrefix}, nil
+}
+
+func (r *etcdRegistry) Watch(ctx context.Context, service string) (<-chan RegistryUpdate, error) {
+	prefix := r.prefix + service + "/"
+	updates := make(chan RegistryUpdate, 1)
+	go func() {
+		defer close(updates)
+		for ctx.Err() == nil {
+			if err := r.watch(ctx, prefix, updates); err != nil && ctx.Err() == nil {
+				// the known instances are kept until the registry is back
+				slog.Warn("etcd watch failed", "prefix", prefix, "error", err)
+				select {
+				case updates <- RegistryUpdate{Err: err}:
+				case <-ctx.Done():
+					return
+				}
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Second):
+				}
+			}
+		}
+	}()
+	return updates, nil
+}
+
+// watch sends the registered instances then their changes , until the watch fails (ex: compacted revision)
+func (r *etcdRegistry) watch(ctx context.Context, prefix string, updates chan<- RegistryUpdate) error {
+	resp, err := r.client.Get(ctx, prefix, etcd.WithPrefix())
+	if err != nil {
+		return err
+	}
+	instances := make(map[string]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		instances[string(kv.Key)] = string(kv.Value)
+	}
+	send := func() bool {
+		select {
+		case updates <- RegistryUpdate{Instances: slices.Compact(slices.Sorted(maps.Values(instances)))}:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	if !send() {
+		return nil
+	}
+
+	for watch := range r.client.Watch(ctx, prefix, etcd.WithPrefix(), etcd.WithRev(resp.Header.Revision+1)) {
+		if err := watch.Err(); err != nil {
+			return err
+		}
+		for _, ev := range watch.Events {
+			switch ev.Type {
+			case etcd.EventTypePut:
+				instances[string(ev.Kv.Key)] = string(ev.Kv.Value)
+			case etcd.EventTypeDelete:
+				delete(instances, string(ev.Kv.Key))
+			}
+		}
+		if !send() {
+			return nil
+		}
+	}
+	return errors.New("watch closed")
+}
+
+func (r *etcdRegistry) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestLookupHosts(t *testing.T) {
+	instances, err := lookupHosts(context.Background(), "10.0.0.2:50081, 10.0.0.1:50081,[::1]:50081,10.0.0.1:50081")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.1:50081", "10.0.0.2:50081", "[::1]:50081"}
+	if !slices.Equal(instances, want) {
+		t.Errorf("instances = %v , want %v", instances, want)
+	}
+	if _, err := lookupHosts(context.Background(), "feed-service"); err == nil {
+		t.Error("an address without port must fail")
+	}
+}
+
+func TestLookupRegistryWatch(t *testing.T) {
+	results := [][]string{{"a:1"}, {"a:1"}, {"a:1", "b:1"}}
+	calls := 0
+	registry := &lookupRegistry{
+		services: map[string]*models.BackendConfig{"feed_service": {Addr: "feed-service:50081"}},
+		interval: time.Millisecond,
+		lookup: func(context.Context, string) ([]string, error) {
+			r := results[min(calls, len(results)-1)]
+			calls++
+			return r, nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates, err := registry.Watch(ctx, "feed_service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same instances are not sent twice
+	if got := <-updates; !slices.Equal(got.Instances, []string{"a:1"}) {
+		t.Errorf("first update = %v", got)
+	}
+	if got := <-updates; !slices.Equal(got.Instances, []string{"a:1", "b:1"}) {
+		t.Errorf("second update = %v", got)
+	}
+	if _, err := registry.Watch(ctx, "unknown"); err == nil {
+		t.Error("watch of an unknown service must fail")
+	}
+}
+
+type fakeRegistry struct {
+	instances []string
+}
+
+func (r *fakeRegistry) Watch(ctx context.Context, service string) (<-chan RegistryUpdate, error) {
+	updates := make(chan RegistryUpdate, 1)
+	updates <- RegistryUpdate{Instances: r.instances}
+	go func() {
+		<-ctx.Done()
+		close(updates)
+	}()
+	return updates, nil
+}
+
+func (r *fakeRegistry) Close() error { return nil }
+
+func TestRegistryResolver(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(lis)
+	defer server.Stop()
+
+	backend := &models.BackendConfig{Balancer: "round_robin"}
+	conn, err := grpc.NewClient(registryScheme+":///feed_service",
+		grpc.WithResolvers(&registryResolver{registry: &fakeRegistry{instances: []string{lis.Addr().String()}}}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(serviceConfig(backend)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+	if err != nil || resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("resp = %v , err = %v", resp, err)
+	}
+}
+
+// chanRegistry sends the instances pushed to updates
+type chanRegistry struct {
+	updates chan RegistryUpdate
+}
+
+func (r *chanRegistry) Watch(context.Context, string) (<-chan RegistryUpdate, error) {
+	return r.updates, nil
+}
+
+func (r *chanRegistry) Close() error { return nil }
+
+func TestRegistryResolverNoInstances(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(lis)
+	defer server.Stop()
+
+	registry := &chanRegistry{updates: make(chan RegistryUpdate, 1)}
+	registry.updates <- RegistryUpdate{Instances: []string{lis.Addr().String()}}
+	conn, err := grpc.NewClient(registryScheme+":///feed_service",
+		grpc.WithResolvers(&registryResolver{registry: registry}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(serviceConfig(&models.BackendConfig{Balancer: "round_robin"})),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the last instance is deregistered , it must not get calls anymore
+	registry.updates <- RegistryUpdate{}
+	for {
+		_, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		if status.Code(err) == codes.Unavailable {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatal("the removed instance still gets calls")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRegistryResolverLookupError(t *testing.T) {
+	registry := &lookupRegistry{
+		services: map[string]*models.BackendConfig{"feed_service": {Addr: "feed-service:50081"}},
+		interval: time.Hour,
+		lookup: func(context.Context, string) ([]string, error) {
+			return nil, errors.New("no such host")
+		},
+	}
+	conn, err := grpc.NewClient(registryScheme+":///feed_service",
+		grpc.WithResolvers(&registryResolver{registry: registry}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(serviceConfig(&models.BackendConfig{Balancer: "round_robin"})),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the call fails fast , it does not wait for the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if status.Code(err) != codes.Unavailable || ctx.Err() != nil {
+		t.Fatalf("err = %v , want Unavailable before the deadline", err)
+	}
+}
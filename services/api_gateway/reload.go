@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// gatewayState is everything built from the config files.
+// It is never modified after being built, a reload builds a new one
+// and swaps it so in-flight requests finish with the old one.
+type gatewayState struct {
+	config      *models.AppConfig
+	grpcInvoker *GRPCInvoker
+	router      *Router
+	rules       *RuleSet
+	openapi     []byte // generated spec of the routes
+	pipelines   map[*models.RouteConfig][]*Middleware
+	cors        *corsPolicy
+	routeCORS   map[*models.RouteConfig]*corsPolicy // routes with a cors override
+	clientIPs   *clientIPResolver
+
+	// reflected descriptors per backend (nil in protoset mode)
+	descriptors     map[string]*descriptorpb.FileDescriptorSet
+	descriptorsHash string
+}
+
+// buildGatewayState loads the service descriptors, builds the route trie and binds the rate limit rules.
+// In strict mode any protoset that fails to load rejects the whole state.
+func buildGatewayState(config *models.AppConfig, serviceConns *ServiceConnections, descriptors map[string]*descriptorpb.FileDescriptorSet, strict bool) (*gatewayState, error) {
+	rules, err := LoadRuleSet(config.RateLimiting)
+	if err != nil {
+		return nil, fmt.Errorf("rate limit rules: %w", err)
+	}
+
+	grpcInvoker := NewGRPCInvoker(config.RouteOptions)
+	if err := loadDescriptors(grpcInvoker, config, descriptors, strict); err != nil {
+		return nil, err
+	}
+
+	cors, err := newCORSPolicy(config.CORS)
+	if err != nil {
+		return nil, err
+	}
+
+	clientIPs, err := newClientIPResolver(config.Server.ClientIPHeader, config.Server.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	router := NewRouter()
+	pipelines := make(map[*models.RouteConfig][]*Middleware)
+	routeCORS := make(map[*models.RouteConfig]*corsPolicy)
+	httpRoutes := grpcInvoker.GetHttpRoutes()
+	for method, routes := range httpRoutes {
+		for path, route := range routes {
+			// Apply route options from config if available
+			var middlewares []models.MiddlewareConfig
+			var routePolicy *corsPolicy
+			applyCallPolicy(route, config.Calls, config.RouteOptions[path])
+			if opts, ok := config.RouteOptions[path]; ok {
+				route.RequireAuth = opts.RequireAuth
+				route.RateLimitEnabled = opts.RateLimitEnabled
+				route.Cache = opts.Cache
+				route.Invalidates = opts.Invalidates
+				// a mutation must never be shared
+				route.Shareable = opts.Shareable && route.Method == http.MethodGet
+				middlewares = opts.Middlewares
+				if opts.CORS != nil {
+					if routePolicy, err = newCORSPolicy(mergeCORS(config.CORS, opts.CORS)); err != nil {
+						return nil, fmt.Errorf("route %s %s: %w", method, path, err)
+					}
+				}
+			}
+			pipeline, err := buildPipeline(route, middlewares)
+			if err != nil {
+				if strict {
+					return nil, fmt.Errorf("route %s %s: %w", method, path, err)
+				}
+				log.Printf("Skipping route %s %s: %v", method, path, err)
+				continue
+			}
+			if _, err := serviceConns.GetConn(route.BackendService); err != nil {
+				if strict {
+					return nil, fmt.Errorf("route %s %s: %w", method, path, err)
+				}
+				log.Printf("Warning: route %s %s has no backend connection", method, path)
+			}
+			if err := router.Insert(route); err != nil {
+				if strict {
+					return nil, err
+				}
+				log.Printf("Skipping route: %v", err)
+				continue
+			}
+			pipelines[route] = pipeline
+			if routePolicy != nil {
+				routeCORS[route] = routePolicy
+			}
+			log.Printf("Registered route: %s %s -> %s/%s (auth=%v, rate_limit=%v)",
+				method, path, route.GRPCService, route.GRPCMethod, route.RequireAuth, route.RateLimitEnabled)
+		}
+	}
+
+	// attach per-route rate limit rules to the matched routes
+	rules.BindRoutes(router)
+
+	var spec []byte
+	if config.OpenAPI.Enabled {
+		spec, err = buildOpenAPI(grpcInvoker, router.Routes(), config.OpenAPI)
+		if err != nil {
+			// the docs never block the routes
+			log.Printf("Warning: Failed to generate the OpenAPI spec: %v", err)
+		}
+	}
+
+	return &gatewayState{
+		config:          config,
+		grpcInvoker:     grpcInvoker,
+		router:          router,
+		rules:           rules,
+		openapi:         spec,
+		pipelines:       pipelines,
+		cors:            cors,
+		routeCORS:       routeCORS,
+		clientIPs:       clientIPs,
+		descriptors:     descriptors,
+		descriptorsHash: descriptorsHash(descriptors),
+	}, nil
+}
+
+// loadDescriptors loads the reflected descriptors of every backend.
+// Backends without reflection (or in protoset mode) use their protoset file.
+func loadDescriptors(grpcInvoker *GRPCInvoker, config *models.AppConfig, descriptors map[string]*descriptorpb.FileDescriptorSet, strict bool) error {
+	for serviceName, fds := range descriptors {
+		grpcInvoker.LoadFileDescriptorSet(fds, serviceName)
+		log.Printf("Successfully loaded reflected descriptors for %s", serviceName)
+	}
+	for serviceName, protofile := range config.ProtoFiles {
+		if _, ok := descriptors[serviceName]; ok {
+			continue
+		}
+		if protofile == "" {
+			log.Printf("Warning: No protoset path configured for service %s", serviceName)
+			continue
+		}
+
+		err := grpcInvoker.LoadProtoset(protofile, serviceName)
+		if err != nil {
+			if strict {
+				return fmt.Errorf("protoset of %s: %w", serviceName, err)
+			}
+			log.Printf("Warning: Failed to load protoset for service %s: %v", serviceName, err)
+		} else {
+			log.Printf("Successfully loaded protoset for %s from %s", serviceName, protofile)
+		}
+	}
+	return nil
+}
+
+// Reload rebuilds the gateway state from the config file and swaps it atomically.
+// Invalid configs are rejected and the current state is kept.
+// NOTE: server address, redis addresses and k8s_services still need a restart
+func (h *Handler) Reload(configPath string) error {
+	config, err := LoadAppConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if err := loadLuaScripts(config); err != nil {
+		return fmt.Errorf("lua scripts: %w", err)
+	}
+
+	h.reloadMu.Lock()
+	defer h.reloadMu.Unlock()
+	current := h.state.Load()
+
+	var descriptors map[string]*descriptorpb.FileDescriptorSet
+	if config.Descriptors.Source == SourceReflection {
+		descriptors = fetchAllDescriptors(h.serviceConns, config.K8sServices, current.descriptors)
+	}
+	st, err := buildGatewayState(config, h.serviceConns, descriptors, true)
+	if err != nil {
+		return err
+	}
+	h.state.Store(st)
+	return nil
+}
+
+// RefreshDescriptors pulls the backends descriptors again
+// and rebuilds the routes only if they changed
+func (h *Handler) RefreshDescriptors() error {
+	h.reloadMu.Lock()
+	defer h.reloadMu.Unlock()
+	current := h.state.Load()
+	if current.config.Descriptors.Source != SourceReflection {
+		return nil
+	}
+
+	descriptors := fetchAllDescriptors(h.serviceConns, current.config.K8sServices, current.descriptors)
+	if descriptorsHash(descriptors) == current.descriptorsHash {
+		return nil
+	}
+	log.Println("Backend descriptors changed, rebuilding routes")
+	st, err := buildGatewayState(current.config, h.serviceConns, descriptors, true)
+	if err != nil {
+		return err
+	}
+	h.state.Store(st)
+	return nil
+}
+
+// WatchDescriptors refreshes the reflected descriptors periodically
+func (h *Handler) WatchDescriptors(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.RefreshDescriptors(); err != nil {
+				log.Printf("Descriptors refresh rejected: %v", err)
+			}
+		}
+	}
+}
+
+// Reloader triggers Handler.Reload on SIGHUP or when one of the config files changes
+type Reloader struct {
+	configPath string
+	handler    *Handler
+	interval   time.Duration
+	modTimes   map[string]time.Time
+}
+
+func NewReloader(configPath string, handler *Handler, interval time.Duration) *Reloader {
+	r := &Reloader{
+		configPath: configPath,
+		handler:    handler,
+		interval:   interval,
+	}
+	r.modTimes = r.snapshot()
+	return r
+}
+
+func (r *Reloader) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// file watching is disabled with interval 0 , SIGHUP still works
+	var tick <-chan time.Time
+	if r.interval > 0 {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Println("SIGHUP received, reloading config")
+			r.reload()
+		case <-tick:
+			if r.changed() {
+				log.Println("Config files changed, reloading config")
+				r.reload()
+			}
+		}
+	}
+}
+
+func (r *Reloader) reload() {
+	if err := r.handler.Reload(r.configPath); err != nil {
+		log.Printf("Config reload rejected: %v", err)
+	} else {
+		log.Println("Config reloaded successfully")
+	}
+	// even if rejected, wait for the next change before trying again
+	r.modTimes = r.snapshot()
+}
+
+func (r *Reloader) changed() bool {
+	current := r.snapshot()
+	if len(current) != len(r.modTimes) {
+		return true
+	}
+	for path, t := range current {
+		if !t.Equal(r.modTimes[path]) {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshot returns the modification time of every file the state is built from
+func (r *Reloader) snapshot() map[string]time.Time {
+	files := []string{r.configPath}
+	config := r.handler.state.Load().config
+	files = append(files, config.RateLimiting.RulesConfig, config.Redis.AddScriptPath, config.Redis.CheckScriptPath)
+	for _, path := range config.RateLimiting.ScriptPaths {
+		files = append(files, path)
+	}
+	for _, path := range config.ProtoFiles {
+		files = append(files, path)
+	}
+
+	modTimes := make(map[string]time.Time, len(files))
+	for _, path := range files {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		modTimes[path] = info.ModTime()
+	}
+	return modTimes
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// applyCallPolicy sets the timeout , retries and hedging of a route from calls and its route_options
+func applyCallPolicy(route *models.RouteConfig, calls models.CallsConfig, opts *models.RouteOption) {
+	route.Timeout = calls.Timeout
+	route.Idempotent = route.Method == http.MethodGet
+	route.Retry = calls.Retry
+	route.Hedge = nil
+	if opts == nil {
+		return
+	}
+	if opts.Timeout > 0 {
+		route.Timeout = opts.Timeout
+	}
+	if opts.Idempotent != nil {
+		route.Idempotent = *opts.Idempotent
+	}
+	if r := opts.Retry; r != nil {
+		if r.MaxAttempts > 0 {
+			route.Retry.MaxAttempts = r.MaxAttempts
+		}
+		if r.InitialBackoff > 0 {
+			route.Retry.InitialBackoff = r.InitialBackoff
+		}
+		if r.MaxBackoff > 0 {
+			route.Retry.MaxBackoff = r.MaxBackoff
+		}
+	}
+	if opts.Hedge != nil {
+		if !route.Idempotent {
+			// a hedged call runs twice
+			slog.Warn("hedge ignored on a non idempotent route", "method", route.Method, "route", route.Path)
+			return
+		}
+		hedge := *opts.Hedge
+		if hedge.MaxAttempts <= 0 {
+			hedge.MaxAttempts = 2
+		}
+		route.Hedge = &hedge
+	}
+}
+
+// invoke calls the backend of a unary route within the route timeout ,
+// retried or hedged if the route is idempotent
+func (h *Handler) invoke(ctx context.Context, st *gatewayState, conn *grpc.ClientConn, route *models.RouteConfig, reqMsg proto.Message) ([]byte, error) {
+	if route.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, route.Timeout)
+		defer cancel()
+	}
+	call := func(ctx context.Context) ([]byte, error) {
+		return st.grpcInvoker.Invoke(ctx, conn, route, reqMsg)
+	}
+	if !route.Idempotent {
+		return call(ctx)
+	}
+
+	budget := h.retryBudget(route.BackendService)
+	budget.deposit(route.Retry)
+	allow := func(kind string) bool {
+		if !budget.withdraw(route.Retry) {
+			retryBudgetExhausted.WithLabelValues(route.BackendService).Inc()
+			return false
+		}
+		grpcRetries.WithLabelValues(route.BackendService, kind).Inc()
+		return true
+	}
+	if route.Hedge != nil {
+		return hedgeCall(ctx, call, *route.Hedge, allow)
+	}
+	return retryCall(ctx, call, route.Retry, allow)
+}
+
+// retryable reports whether another call may succeed.
+// The errors made by the gateway itself (ex: an open circuit breaker) are not retried.
+func retryable(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok {
+		return false
+	}
+	if st.Code() != codes.Unavailable && st.Code() != codes.ResourceExhausted {
+		return false
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.GetDomain() == errorDomain {
+			return false
+		}
+	}
+	return true
+}
+
+// retryCall retries with exponential backoff and full jitter until the attempts , the budget or the deadline are spent
+func retryCall(ctx context.Context, call func(context.Context) ([]byte, error), cfg models.RetryConfig, allow func(string) bool) ([]byte, error) {
+	backoff := cfg.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := call(ctx)
+		if err == nil || attempt >= cfg.MaxAttempts || !retryable(err) {
+			return resp, err
+		}
+		delay := rand.N(backoff) + time.Millisecond
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return resp, err
+		}
+		if !allow("retry") {
+			return resp, err
+		}
+		slog.DebugContext(ctx, "retrying backend call", "attempt", attempt+1, "delay", delay, "error", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, err
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, cfg.MaxBackoff)
+	}
+}
+
+// hedgeCall sends another call each time the previous ones have no response after cfg.Delay
+// (or failed with a retryable error). The first response wins , the other calls are canceled.
+func hedgeCall(ctx context.Context, call func(context.Context) ([]byte, error), cfg models.HedgeConfig, allow func(string) bool) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		resp []byte
+		err  error
+	}
+	// buffered , the canceled calls never block
+	results := make(chan result, cfg.MaxAttempts)
+	launch := func() {
+		go func() {
+			resp, err := call(ctx)
+			results <- result{resp, err}
+		}()
+	}
+
+	launch()
+	sent, inflight := 1, 1
+	timer := time.NewTimer(cfg.Delay)
+	defer timer.Stop()
+	var lastErr error
+	for inflight > 0 {
+		select {
+		case r := <-results:
+			inflight--
+			if r.err == nil {
+				return r.resp, nil
+			}
+			if status.Code(r.err) != codes.Canceled {
+				lastErr = r.err
+			}
+			if !retryable(r.err) {
+				return nil, r.err
+			}
+		case <-timer.C:
+		}
+		if sent < cfg.MaxAttempts && ctx.Err() == nil && allow("hedge") {
+			launch()
+			sent++
+			inflight++
+			timer.Reset(cfg.Delay)
+		}
+	}
+	return nil, lastErr
+}
+
+// retryBudget limits the retries and hedged calls of a backend , it earns BudgetRatio of a token per call
+// and BudgetMinRetries tokens per second (capped to 10 seconds of them) , every retry spends one token
+type retryBudget struct {
+	mu      sync.Mutex
+	tokens  float64
+	updated time.Time
+}
+
+func (h *Handler) retryBudget(backend string) *retryBudget {
+	budget, _ := h.retryBudgets.LoadOrStore(backend, &retryBudget{updated: time.Now()})
+	return budget.(*retryBudget)
+}
+
+func (b *retryBudget) refill(cfg models.RetryConfig, earned float64) {
+	now := time.Now()
+	capacity := float64(max(cfg.BudgetMinRetries, 1)) * 10
+	b.tokens = min(b.tokens+earned+now.Sub(b.updated).Seconds()*float64(cfg.BudgetMinRetries), capacity)
+	b.updated = now
+}
+
+func (b *retryBudget) deposit(cfg models.RetryConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(cfg, cfg.BudgetRatio)
+}
+
+func (b *retryBudget) withdraw(cfg models.RetryConfig) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(cfg, 0)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var testRetry = models.RetryConfig{
+	MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond,
+	BudgetRatio: 0.2, BudgetMinRetries: 10,
+}
+
+func allowAll(string) bool { return true }
+
+func TestRetryCall(t *testing.T) {
+	var calls atomic.Int32
+	call := func(context.Context) ([]byte, error) {
+		if calls.Add(1) < 3 {
+			return nil, status.Error(codes.Unavailable, "")
+		}
+		return []byte("ok"), nil
+	}
+	resp, err := retryCall(context.Background(), call, testRetry, allowAll)
+	if err != nil || string(resp) != "ok" || calls.Load() != 3 {
+		t.Fatalf("resp = %q , err = %v after %d calls", resp, err, calls.Load())
+	}
+
+	// not retryable
+	calls.Store(0)
+	_, err = retryCall(context.Background(), func(context.Context) ([]byte, error) {
+		calls.Add(1)
+		return nil, status.Error(codes.InvalidArgument, "")
+	}, testRetry, allowAll)
+	if status.Code(err) != codes.InvalidArgument || calls.Load() != 1 {
+		t.Errorf("err = %v after %d calls , want a single call", err, calls.Load())
+	}
+
+	// the gateway own errors (ex: open breaker) are not retried
+	calls.Store(0)
+	retryCall(context.Background(), func(context.Context) ([]byte, error) {
+		calls.Add(1)
+		return nil, grpcError(codes.Unavailable, "CIRCUIT_OPEN", "Service not available").Err()
+	}, testRetry, allowAll)
+	if calls.Load() != 1 {
+		t.Errorf("%d calls with an open breaker , want 1", calls.Load())
+	}
+
+	// no budget , no retry
+	calls.Store(0)
+	retryCall(context.Background(), func(context.Context) ([]byte, error) {
+		calls.Add(1)
+		return nil, status.Error(codes.Unavailable, "")
+	}, testRetry, func(string) bool { return false })
+	if calls.Load() != 1 {
+		t.Errorf("%d calls without budget , want 1", calls.Load())
+	}
+}
+
+func TestHedgeCall(t *testing.T) {
+	var calls atomic.Int32
+	call := func(ctx context.Context) ([]byte, error) {
+		if calls.Add(1) == 1 {
+			// the slow first call is canceled once the hedged one answers
+			<-ctx.Done()
+			return nil, status.Error(codes.Canceled, "")
+		}
+		return []byte("hedged"), nil
+	}
+	start := time.Now()
+	resp, err := hedgeCall(context.Background(), call, models.HedgeConfig{Delay: 10 * time.Millisecond, MaxAttempts: 2}, allowAll)
+	if err != nil || string(resp) != "hedged" {
+		t.Fatalf("resp = %q , err = %v", resp, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("hedged call took %v", elapsed)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	cfg := models.RetryConfig{BudgetRatio: 0.5, BudgetMinRetries: 0}
+	b := &retryBudget{updated: time.Now()}
+	b.deposit(cfg)
+	b.deposit(cfg)
+	if !b.withdraw(cfg) {
+		t.Fatal("2 calls at 0.5 must allow a retry")
+	}
+	if b.withdraw(cfg) {
+		t.Fatal("the budget is spent")
+	}
+}
+
+func TestApplyCallPolicy(t *testing.T) {
+	calls := models.CallsConfig{Timeout: 10 * time.Second, Retry: testRetry}
+
+	get := &models.RouteConfig{Method: "GET", Path: "/api/v1/feed"}
+	applyCallPolicy(get, calls, &models.RouteOption{Timeout: time.Second, Hedge: &models.HedgeConfig{Delay: time.Millisecond}})
+	if !get.Idempotent || get.Timeout != time.Second || get.Hedge == nil || get.Hedge.MaxAttempts != 2 {
+		t.Errorf("GET route = %+v", get)
+	}
+
+	post := &models.RouteConfig{Method: "POST", Path: "/api/v1/posts"}
+	applyCallPolicy(post, calls, &models.RouteOption{Hedge: &models.HedgeConfig{Delay: time.Millisecond}})
+	if post.Idempotent || post.Hedge != nil || post.Timeout != 10*time.Second {
+		t.Errorf("POST route = %+v", post)
+	}
+
+	idempotent := true
+	put := &models.RouteConfig{Method: "PUT", Path: "/api/v1/follow"}
+	applyCallPolicy(put, calls, &models.RouteOption{Idempotent: &idempotent, Retry: &models.RetryConfig{MaxAttempts: 5}})
+	if !put.Idempotent || put.Retry.MaxAttempts != 5 || put.Retry.InitialBackoff != testRetry.InitialBackoff {
+		t.Errorf("PUT route = %+v", put)
+	}
+}
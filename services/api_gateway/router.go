@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+)
+
+// Router is a compiled path trie built from the google.api.http routes.
+// It is shared by the handler (route lookup) and the rate limiter
+// (per-route rules), so a request is matched only once.
+type Router struct {
+	root *routeNode
+}
+
+// Every node is one path segment. Literal segments are kept in children,
+// "*" (and {param}) segments go to the wildcard child and "**" to the deep one.
+// The variables are stored on the leaf since two routes
+// can use different names in the same position (ex: {UserId} , {FolloweeId})
+type routeNode struct {
+	children map[string]*routeNode
+	wildcard *routeNode
+	deep     *routeNode
+	routes   map[string]*routeLeaf // method:verb -> leaf
+}
+
+type routeLeaf struct {
+	route    *models.RouteConfig
+	template *pathTemplate
+}
+
+func NewRouter() *Router {
+	return &Router{root: newRouteNode()}
+}
+
+func newRouteNode() *routeNode {
+	return &routeNode{
+		children: make(map[string]*routeNode),
+		routes:   make(map[string]*routeLeaf),
+	}
+}
+
+func leafKey(method, verb string) string {
+	return method + ":" + verb
+}
+
+// Insert adds a route to the trie under its method and path template
+func (rt *Router) Insert(route *models.RouteConfig) error {
+	tmpl, err := parsePathTemplate(route.Path)
+	if err != nil {
+		return err
+	}
+	node := rt.root
+	for _, seg := range tmpl.segments {
+		node = node.child(seg, true)
+	}
+	key := leafKey(route.Method, tmpl.verb)
+	if leaf, ok := node.routes[key]; ok {
+		return fmt.Errorf("route %s %s conflicts with %s %s", route.Method, route.Path, leaf.route.Method, leaf.route.Path)
+	}
+	node.routes[key] = &routeLeaf{route: route, template: tmpl}
+	return nil
+}
+
+func (n *routeNode) child(seg templateSegment, create bool) *routeNode {
+	var next **routeNode
+	switch seg.kind {
+	case segWildcard:
+		next = &n.wildcard
+	case segDeepWildcard:
+		next = &n.deep
+	default:
+		child, ok := n.children[seg.literal]
+		if !ok && create {
+			child = newRouteNode()
+			n.children[seg.literal] = child
+		}
+		return child
+	}
+	if *next == nil && create {
+		*next = newRouteNode()
+	}
+	return *next
+}
+
+// Lookup returns the route registered with exactly this method and template.
+// Variable names are ignored so "/users/{id}" finds "/users/{UserId}".
+func (rt *Router) Lookup(method, pattern string) *models.RouteConfig {
+	tmpl, err := parsePathTemplate(pattern)
+	if err != nil {
+		return nil
+	}
+	node := rt.root
+	for _, seg := range tmpl.segments {
+		if node = node.child(seg, false); node == nil {
+			return nil
+		}
+	}
+	if leaf, ok := node.routes[leafKey(method, tmpl.verb)]; ok {
+		return leaf.route
+	}
+	return nil
+}
+
+// Match finds the route for a request path and extracts its variables (field path -> value).
+// Literal segments win over "*" and "*" over "**", e.g /posts/post is matched before /posts/{PostId}.
+// pathFound reports if the path exists under any other method (used for 405).
+func (rt *Router) Match(method, path string) (route *models.RouteConfig, params map[string]string, pathFound bool) {
+	segs := splitPath(path)
+	if leaf, values := rt.match(method, "", segs, &pathFound); leaf != nil {
+		return leaf.route, leaf.template.bind(values), true
+	}
+	// custom methods like /v1/posts/1:publish
+	if n := len(segs); n > 0 {
+		if last, verb, ok := strings.Cut(segs[n-1], ":"); ok {
+			segs = append(segs[:n-1:n-1], last)
+			if leaf, values := rt.match(method, verb, segs, &pathFound); leaf != nil {
+				return leaf.route, leaf.template.bind(values), true
+			}
+		}
+	}
+	return nil, nil, pathFound
+}
+
+func (rt *Router) match(method, verb string, segs []string, pathFound *bool) (*routeLeaf, []string) {
+	values := make([]string, 0, len(segs))
+	leaf := rt.root.match(leafKey(method, verb), segs, &values, pathFound)
+	return leaf, values
+}
+
+// match walks the trie and backtracks to the wildcard children
+// when the literal branch has no route for this method
+func (n *routeNode) match(key string, segs []string, values *[]string, pathFound *bool) *routeLeaf {
+	if len(segs) == 0 {
+		if len(n.routes) > 0 {
+			*pathFound = true
+		}
+		if leaf, ok := n.routes[key]; ok {
+			return leaf
+		}
+		// "**" also matches zero segments
+		if n.deep != nil {
+			return n.deep.match(key, segs, values, pathFound)
+		}
+		return nil
+	}
+	seg := segs[0]
+	if child, ok := n.children[seg]; ok {
+		*values = append(*values, seg)
+		if leaf := child.match(key, segs[1:], values, pathFound); leaf != nil {
+			return leaf
+		}
+		*values = (*values)[:len(*values)-1]
+	}
+	if n.wildcard != nil && seg != "" {
+		*values = append(*values, seg)
+		if leaf := n.wildcard.match(key, segs[1:], values, pathFound); leaf != nil {
+			return leaf
+		}
+		*values = (*values)[:len(*values)-1]
+	}
+	if n.deep != nil {
+		if len(n.deep.routes) > 0 {
+			*pathFound = true
+		}
+		if leaf, ok := n.deep.routes[key]; ok {
+			*values = append(*values, strings.Join(segs, "/"))
+			return leaf
+		}
+	}
+	return nil
+}
+
+// Routes returns all registered routes grouped as method -> path -> route
+func (rt *Router) Routes() map[string]map[string]*models.RouteConfig {
+	routes := make(map[string]map[string]*models.RouteConfig)
+	var walk func(n *routeNode)
+	walk = func(n *routeNode) {
+		if n == nil {
+			return
+		}
+		for _, leaf := range n.routes {
+			method := leaf.route.Method
+			if routes[method] == nil {
+				routes[method] = make(map[string]*models.RouteConfig)
+			}
+			routes[method][leaf.route.Path] = leaf.route
+		}
+		for _, child := range n.children {
+			walk(child)
+		}
+		walk(n.wildcard)
+		walk(n.deep)
+	}
+	walk(rt.root)
+	return routes
+}
+
+// splitPath splits the escaped path, so an encoded "/" (%2F) stays inside its segment
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	segs := strings.Split(path, "/")
+	for i, seg := range segs {
+		if unescaped, err := url.PathUnescape(seg); err == nil {
+			segs[i] = unescaped
+		}
+	}
+	return segs
+}
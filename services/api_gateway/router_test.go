@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+)
+
+func newTestRouter(t *testing.T) *Router {
+	rt := NewRouter()
+	routes := []*models.RouteConfig{
+		{Method: "POST", Path: "/api/v1/posts/post"},
+		{Method: "GET", Path: "/api/v1/posts/{PostId}"},
+		{Method: "GET", Path: "/api/v1/followers/{UserId}"},
+		{Method: "POST", Path: "/api/v1/follow/{FolloweeId}"},
+		{Method: "GET", Path: "/api/v1/feed"},
+	}
+	for _, route := range routes {
+		if err := rt.Insert(route); err != nil {
+			t.Fatalf("Insert(%s %s) failed: %v", route.Method, route.Path, err)
+		}
+	}
+	return rt
+}
+
+func TestRouterMatch(t *testing.T) {
+	rt := newTestRouter(t)
+
+	tests := []struct {
+		method    string
+		path      string
+		wantPath  string
+		wantParam map[string]string
+		pathFound bool
+	}{
+		{"POST", "/api/v1/posts/post", "/api/v1/posts/post", map[string]string{}, true},
+		{"GET", "/api/v1/posts/post", "/api/v1/posts/{PostId}", map[string]string{"PostId": "post"}, true},
+		{"GET", "/api/v1/posts/42", "/api/v1/posts/{PostId}", map[string]string{"PostId": "42"}, true},
+		{"GET", "/api/v1/followers/7/", "/api/v1/followers/{UserId}", map[string]string{"UserId": "7"}, true},
+		{"POST", "/api/v1/follow/9", "/api/v1/follow/{FolloweeId}", map[string]string{"FolloweeId": "9"}, true},
+		{"DELETE", "/api/v1/feed", "", nil, true},
+		{"GET", "/api/v1/unknown", "", nil, false},
+		{"GET", "/api/v1/posts", "", nil, false},
+	}
+
+	for _, tt := range tests {
+		route, params, pathFound := rt.Match(tt.method, tt.path)
+		if pathFound != tt.pathFound {
+			t.Errorf("Match(%s %s) pathFound = %v, want %v", tt.method, tt.path, pathFound, tt.pathFound)
+		}
+		if tt.wantPath == "" {
+			if route != nil {
+				t.Errorf("Match(%s %s) = %s, want no route", tt.method, tt.path, route.Path)
+			}
+			continue
+		}
+		if route == nil || route.Path != tt.wantPath {
+			t.Errorf("Match(%s %s) = %v, want %s", tt.method, tt.path, route, tt.wantPath)
+			continue
+		}
+		if len(params) != len(tt.wantParam) {
+			t.Errorf("Match(%s %s) params = %v, want %v", tt.method, tt.path, params, tt.wantParam)
+		}
+		for k, v := range tt.wantParam {
+			if params[k] != v {
+				t.Errorf("Match(%s %s) params[%s] = %q, want %q", tt.method, tt.path, k, params[k], v)
+			}
+		}
+	}
+}
+
+func TestRouterLookupAndConflicts(t *testing.T) {
+	rt := newTestRouter(t)
+
+	if route := rt.Lookup("GET", "/api/v1/posts/{id}"); route == nil || route.Path != "/api/v1/posts/{PostId}" {
+		t.Errorf("Lookup by pattern failed, got %v", route)
+	}
+	if route := rt.Lookup("POST", "/api/v1/posts/{id}"); route != nil {
+		t.Errorf("Lookup with wrong method = %v, want nil", route)
+	}
+	if err := rt.Insert(&models.RouteConfig{Method: "GET", Path: "/api/v1/posts/{Id}"}); err == nil {
+		t.Error("Insert of a conflicting route should fail")
+	}
+}
+
+func TestRouterTemplates(t *testing.T) {
+	rt := NewRouter()
+	routes := []*models.RouteConfig{
+		{Method: "GET", Path: "/v1/posts/{post.id}"},
+		{Method: "GET", Path: "/v1/{name=shelves/*/books/*}"},
+		{Method: "GET", Path: "/v1/files/{path=**}"},
+		{Method: "POST", Path: "/v1/posts/{post.id}:like"},
+	}
+	for _, route := range routes {
+		if err := rt.Insert(route); err != nil {
+			t.Fatalf("Insert(%s %s) failed: %v", route.Method, route.Path, err)
+		}
+	}
+
+	tests := []struct {
+		method    string
+		path      string
+		wantPath  string
+		wantParam map[string]string
+	}{
+		{"GET", "/v1/posts/42", "/v1/posts/{post.id}", map[string]string{"post.id": "42"}},
+		{"GET", "/v1/shelves/1/books/2", "/v1/{name=shelves/*/books/*}", map[string]string{"name": "shelves/1/books/2"}},
+		{"GET", "/v1/files/a/b/c.txt", "/v1/files/{path=**}", map[string]string{"path": "a/b/c.txt"}},
+		{"POST", "/v1/posts/42:like", "/v1/posts/{post.id}:like", map[string]string{"post.id": "42"}},
+		{"GET", "/v1/posts/a%2Fb", "/v1/posts/{post.id}", map[string]string{"post.id": "a/b"}},
+	}
+	for _, tt := range tests {
+		route, params, _ := rt.Match(tt.method, tt.path)
+		if route == nil || route.Path != tt.wantPath {
+			t.Errorf("Match(%s %s) = %v, want %s", tt.method, tt.path, route, tt.wantPath)
+			continue
+		}
+		for k, v := range tt.wantParam {
+			if params[k] != v {
+				t.Errorf("Match(%s %s) params[%s] = %q, want %q", tt.method, tt.path, k, params[k], v)
+			}
+		}
+	}
+
+	if _, err := parsePathTemplate("/v1/{name=**}/books"); err == nil {
+		t.Error("** in the middle of a template should fail")
+	}
+}
@@ -0,0 +1,36 @@
+-- Fixed Window
+-- KEYS[1]: counter key
+-- ARGV[1]: limit (requests per window)
+-- ARGV[2]: window (ms)
+-- Returns: [allowed (1/0), remaining, limit, retry_after_seconds]
+local key = KEYS[1]
+
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local start = now - (now % window)
+
+local data = redis.call("hmget" , key , "start" , "count")
+local count = tonumber(data[2]) or 0
+
+-- new window, reset the counter
+if tonumber(data[1]) ~= start then
+    count = 0
+end
+
+local allowed = count < limit
+if allowed then
+    count = count + 1
+end
+
+redis.call("hset" , key , "start" , start , "count" , count)
+redis.call("pexpire" , key , start + window - now)
+
+local retry_after = 0
+if not allowed then
+    retry_after = math.ceil((start + window - now) / 1000)
+end
+
+return {allowed and 1 or 0, limit - count, limit, retry_after}
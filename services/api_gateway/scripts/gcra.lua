@@ -0,0 +1,38 @@
+-- GCRA (Generic Cell Rate Algorithm)
+-- Stores only the theoretical arrival time (TAT) of the next request
+-- KEYS[1]: tat key
+-- ARGV[1]: rate (requests/s)
+-- ARGV[2]: burst (max requests at once)
+-- Returns: [allowed (1/0), remaining, limit, retry_after_seconds]
+local key = KEYS[1]
+
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+-- emission interval (ms between two requests) and the allowed burst tolerance
+local interval = 1000 / rate
+local tolerance = interval * burst
+
+local tat = tonumber(redis.call("get" , key))
+if tat == nil or tat < now then
+    tat = now
+end
+
+local new_tat = tat + interval
+local allow_at = new_tat - tolerance
+local allowed = now >= allow_at
+
+local retry_after = 0
+if allowed then
+    tat = new_tat
+    redis.call("set" , key , tat , "PX" , math.ceil(tat - now))
+else
+    retry_after = math.ceil((allow_at - now) / 1000)
+end
+
+local remaining = math.floor((now - (tat - tolerance)) / interval)
+
+return {allowed and 1 or 0, math.max(0 , remaining), burst, retry_after}
@@ -1,9 +1,16 @@
+-- Token Bucket
+-- KEYS[1]: bucket key
+-- ARGV[1]: refill rate (tokens/s)
+-- ARGV[2]: bucket size
+-- Returns: [allowed (1/0), remaining_tokens, limit, retry_after_seconds]
 local key = KEYS[1]
 
 local refillrate = tonumber(ARGV[1])
 local limit = tonumber(ARGV[2])
-local now = tonumber(ARGV[3])
 
+-- ms precision from the redis clock so all gateways share the same time
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
 
 
 local data = redis.call("hmget" , key , "tokens" , "last_refill")
@@ -23,7 +30,7 @@ end
 
 -- Ensure delta is postive number
 local delta = math.max(0 , now - last_refill)
-local new_tokens = math.min(limit , curr_tokens + (refillrate*delta))
+local new_tokens = math.min(limit , curr_tokens + (refillrate * delta / 1000))
 local allowed = new_tokens >= 1 
 
 if allowed then 
@@ -33,15 +40,14 @@ end
 -- Expire after ttl to avoid memory leaks
 -- we can set expire after N seconds but to make it more 
 -- generic let we expire after C * (limit/rate)
-local ttl = 3 * (limit/refillrate)
+local ttl = math.ceil(3 * 1000 * (limit/refillrate))
 
 redis.call("hset" , key , "tokens" , new_tokens , "last_refill" , now)
-redis.call("expire" , key , ttl)
+redis.call("pexpire" , key , ttl)
 
--- Return array with: [allowed (1/0), remaining_tokens, limit, retry_after_seconds]
 local retry_after = 0
 if not allowed and refillrate > 0 then
-    retry_after = math.ceil(1 / refillrate)
+    retry_after = math.ceil((1 - new_tokens) / refillrate)
 end
 
 return {allowed and 1 or 0, math.floor(new_tokens), limit, retry_after}
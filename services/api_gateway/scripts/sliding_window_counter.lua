@@ -0,0 +1,54 @@
+-- Sliding Window Counter
+-- Approximates the sliding window using the counters of the current and previous fixed windows
+-- KEYS[1]: counter key
+-- ARGV[1]: limit (requests per window)
+-- ARGV[2]: window (ms)
+-- Returns: [allowed (1/0), remaining, limit, retry_after_seconds]
+local key = KEYS[1]
+
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local start = now - (now % window)
+
+local data = redis.call("hmget" , key , "start" , "curr" , "prev")
+local stored_start = tonumber(data[1])
+local curr = tonumber(data[2]) or 0
+local prev = tonumber(data[3]) or 0
+
+-- move the windows forward if needed
+if stored_start == nil or stored_start < start - window then
+    prev = 0
+    curr = 0
+elseif stored_start == start - window then
+    prev = curr
+    curr = 0
+end
+
+-- weight of the previous window is the part of it still inside the sliding window
+local weight = (window - (now - start)) / window
+local estimated = prev * weight + curr
+local allowed = estimated + 1 <= limit
+
+if allowed then
+    curr = curr + 1
+    estimated = estimated + 1
+end
+
+redis.call("hset" , key , "start" , start , "curr" , curr , "prev" , prev)
+redis.call("pexpire" , key , 2 * window)
+
+local retry_after = 0
+if not allowed then
+    if prev > 0 and curr < limit then
+        -- time until enough of the previous window slides out
+        local needed = (estimated + 1 - limit) / prev * window
+        retry_after = math.ceil(needed / 1000)
+    else
+        retry_after = math.ceil((start + window - now) / 1000)
+    end
+end
+
+return {allowed and 1 or 0, math.max(0 , math.floor(limit - estimated)), limit, retry_after}
@@ -0,0 +1,37 @@
+-- Sliding Window Log
+-- Keeps the timestamp of every accepted request in a sorted set
+-- KEYS[1]: log key
+-- ARGV[1]: limit (requests per window)
+-- ARGV[2]: window (ms)
+-- Returns: [allowed (1/0), remaining, limit, retry_after_seconds]
+local key = KEYS[1]
+
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+-- drop requests that left the window
+redis.call("zremrangebyscore" , key , "-inf" , now - window)
+
+local count = redis.call("zcard" , key)
+local allowed = count < limit
+
+if allowed then
+    -- member must be unique, count always grows inside the same ms
+    redis.call("zadd" , key , now , now .. ":" .. count)
+    count = count + 1
+end
+redis.call("pexpire" , key , window)
+
+local retry_after = 0
+if not allowed then
+    -- wait until the oldest request leaves the window
+    local oldest = redis.call("zrange" , key , 0 , 0 , "WITHSCORES")
+    if oldest[2] ~= nil then
+        retry_after = math.ceil((tonumber(oldest[2]) + window - now) / 1000)
+    end
+end
+
+return {allowed and 1 or 0, limit - count, limit, retry_after}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"log"
 	"net"
 	"net/http"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type Server struct {
@@ -44,26 +46,37 @@ func (s *Server) start() error {
 }
 
 func (s *Server) addRoutes() {
-	// Get routes from handler's route map (built from google.api.http annotations)
+	// All API routes go to the GenericHandler, which matches them
+	// against the handler's route trie (built from google.api.http annotations)
+	s.router.HandleFunc("/", s.handler.GenericHandler)
 	routeMap := s.handler.GetRouteMap()
 	for method, routes := range routeMap {
 		for path, route := range routes {
-			pattern := method + " " + path
-			s.router.HandleFunc(pattern, s.handler.GenericHandler)
-
 			log.Printf("Registered route: %s %s -> %s.%s",
 				method, path, route.GRPCService, route.GRPCMethod)
 		}
 	}
+	// API docs generated from the descriptors
+	s.router.HandleFunc("GET /openapi.json", s.handler.OpenAPIHandler)
+	s.router.HandleFunc("GET /docs", s.handler.DocsHandler)
+	s.router.HandleFunc("GET /docs/{file}", s.handler.DocsAssetHandler)
+	// Prometheus metrics
+	s.router.Handle("GET /metrics", promhttp.Handler())
 	// Health check endpoint
+	// an open backend breaker doesn`t make the gateway unhealthy , it is only reported
 	s.router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		health := struct {
+			Status   string            `json:"status"`
+			Breakers map[string]string `json:"breakers"`
+		}{Status: "healthy", Breakers: s.handler.serviceConns.BreakerStates()}
+		code := http.StatusOK
 		if s.serviceOFF.Load() {
-			w.WriteHeader(http.StatusServiceUnavailable)
-			w.Write([]byte(`{"status": "unhealth"}`))
-		} else {
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte(`{"status": "healthy"}`))
+			health.Status = "unhealth"
+			code = http.StatusServiceUnavailable
 		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(health)
 	})
 }
 
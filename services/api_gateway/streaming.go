@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	contentTypeSSE    = "text/event-stream"
+	contentTypeNDJSON = "application/x-ndjson"
+)
+
+// streamWriter writes the messages of a server-streaming call as SSE events or NDJSON lines.
+// Headers are sent with the first write , so an error before it still gets a proper HTTP status.
+type streamWriter struct {
+	mu           sync.Mutex // heartbeats are written from another goroutine
+	w            http.ResponseWriter
+	rc           *http.ResponseController
+	sse          bool
+	started      bool
+	seq          int
+	writeTimeout time.Duration
+}
+
+func newStreamWriter(w http.ResponseWriter, r *http.Request, writeTimeout time.Duration) *streamWriter {
+	return &streamWriter{
+		w:            w,
+		rc:           http.NewResponseController(w),
+		sse:          strings.Contains(r.Header.Get("Accept"), contentTypeSSE),
+		writeTimeout: writeTimeout,
+	}
+}
+
+func (sw *streamWriter) start() {
+	if sw.started {
+		return
+	}
+	sw.started = true
+	if sw.sse {
+		sw.w.Header().Set("Content-Type", contentTypeSSE)
+	} else {
+		sw.w.Header().Set("Content-Type", contentTypeNDJSON)
+	}
+	sw.w.Header().Set("Cache-Control", "no-cache")
+	sw.w.Header().Set("X-Accel-Buffering", "no") // nginx buffers responses by default
+	sw.w.WriteHeader(http.StatusOK)
+}
+
+// write sends one chunk and flushes it. It blocks while the client is not reading
+// (that is the backpressure) until the write timeout drops the client.
+func (sw *streamWriter) write(chunk string) error {
+	if sw.writeTimeout > 0 {
+		if err := sw.rc.SetWriteDeadline(time.Now().Add(sw.writeTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+	}
+	if _, err := sw.w.Write([]byte(chunk)); err != nil {
+		return err
+	}
+	return sw.rc.Flush()
+}
+
+// Send writes one response message
+func (sw *streamWriter) Send(data []byte) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.start()
+	sw.seq++
+	if sw.sse {
+		return sw.write(fmt.Sprintf("id: %d\ndata: %s\n\n", sw.seq, data))
+	}
+	return sw.write(string(data) + "\n")
+}
+
+// Heartbeat keeps idle SSE connections open through proxies (NDJSON has no comments)
+func (sw *streamWriter) Heartbeat() error {
+	if !sw.sse {
+		return nil
+	}
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.start()
+	return sw.write(": ping\n\n")
+}
+
+// Error ends the stream with a google.rpc.Status , as the HTTP response if nothing is sent yet
+func (sw *streamWriter) Error(st *status.Status) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if !sw.started {
+		writeStatus(sw.w, st)
+		return
+	}
+	if sw.sse {
+		sw.write(fmt.Sprintf("event: error\ndata: %s\n\n", statusJSON(st)))
+		return
+	}
+	sw.write(fmt.Sprintf(`{"error":%s}`+"\n", statusJSON(st)))
+}
+
+// serveStream proxies a server-streaming route until the backend ends the stream,
+// the client disconnects or the stream deadline is reached.
+func (h *Handler) serveStream(w http.ResponseWriter, r *http.Request, st *gatewayState, conn *grpc.ClientConn, route *models.RouteConfig, reqMsg proto.Message) {
+	cfg := st.config.Streaming
+
+	// r.Context() is canceled when the client disconnects
+	ctx := r.Context()
+	if cfg.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.MaxDuration)
+		defer cancel()
+	}
+
+	sw := newStreamWriter(w, r, cfg.WriteTimeout)
+
+	if cfg.HeartbeatInterval > 0 {
+		// no writes are allowed after the handler returns , wait for the heartbeats to stop
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		heartbeatCtx, stopHeartbeat := context.WithCancel(ctx)
+		defer stopHeartbeat()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(cfg.HeartbeatInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-heartbeatCtx.Done():
+					return
+				case <-ticker.C:
+					if err := sw.Heartbeat(); err != nil {
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	err := st.grpcInvoker.InvokeStream(ctx, conn, route, reqMsg, sw.Send)
+	if err == nil {
+		return
+	}
+	if r.Context().Err() != nil || errors.Is(err, errClientGone) {
+		slog.DebugContext(r.Context(), "stream closed by the client", "route", route.Path, "error", err)
+		return
+	}
+	slog.WarnContext(r.Context(), "stream error", "route", route.Path, "error", err)
+	grpcStatus, ok := status.FromError(err)
+	if !ok {
+		grpcStatus = status.New(codes.Internal, "")
+	}
+	sw.Error(sanitizeStatus(grpcStatus))
+}
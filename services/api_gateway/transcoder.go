@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var (
+	// errUnknownField is returned when a path/query param has no matching request field
+	errUnknownField = errors.New("unknown field")
+	errInvalidBody  = errors.New("invalid body")
+)
+
+// fieldError is a path/query param that can`t be set on the request (sent back as a BadRequest violation)
+type fieldError struct {
+	field string
+	err   error
+}
+
+func (e *fieldError) Error() string { return fmt.Sprintf("field %s: %v", e.field, e.err) }
+
+func (e *fieldError) Unwrap() error { return e.err }
+
+// BuildRequest transcodes an HTTP request into the gRPC input message of the route
+// following the google.api.http rules:
+//   - body "*" maps the whole body to the request and no query params are used
+//   - body "field" maps the body to this field, other fields come from path & query
+//   - path variables (even nested ones like {post.id}) override the body
+//   - query params map to (nested or repeated) fields not bound by path or body
+//
+// injected are values set by the gateway itself (ex: tokens) if the request has such fields.
+func (g *GRPCInvoker) BuildRequest(route *models.RouteConfig, body []byte, pathParams map[string]string, query url.Values, injected map[string]string) (*dynamicpb.Message, error) {
+	md, err := g.method(route.GRPCService, route.GRPCMethod)
+	if err != nil {
+		return nil, err
+	}
+	msg := dynamicpb.NewMessage(md.inputDescriptor)
+	unmarshaler := protojson.UnmarshalOptions{DiscardUnknown: true}
+
+	switch route.Body {
+	case "":
+		// no body for this route
+	case "*":
+		if len(body) > 0 {
+			if err := unmarshaler.Unmarshal(body, msg); err != nil {
+				return nil, fmt.Errorf("%w: %v", errInvalidBody, err)
+			}
+		}
+	default:
+		if len(body) > 0 {
+			fd := findField(md.inputDescriptor, route.Body)
+			if fd == nil {
+				return nil, fmt.Errorf("body field %s: %w", route.Body, errUnknownField)
+			}
+			// wrap the body so protojson handles any field type (message, repeated, scalar)
+			wrapped, err := json.Marshal(map[string]json.RawMessage{string(fd.Name()): body})
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", errInvalidBody, err)
+			}
+			tmp := dynamicpb.NewMessage(md.inputDescriptor)
+			if err := unmarshaler.Unmarshal(wrapped, tmp); err != nil {
+				return nil, fmt.Errorf("%w: %v", errInvalidBody, err)
+			}
+			proto.Merge(msg, tmp)
+		}
+	}
+
+	for fieldPath, value := range pathParams {
+		if err := setField(msg, fieldPath, []string{value}); err != nil {
+			return nil, &fieldError{field: fieldPath, err: err}
+		}
+	}
+
+	if route.Body != "*" {
+		for key, values := range query {
+			if isBoundField(key, route.Body, pathParams) {
+				continue
+			}
+			if err := setField(msg, key, values); err != nil {
+				// clients can send params we don`t know (ex: tracking)
+				if errors.Is(err, errUnknownField) {
+					continue
+				}
+				return nil, &fieldError{field: key, err: err}
+			}
+		}
+	}
+
+	for name, value := range injected {
+		fd := findField(md.inputDescriptor, name)
+		if fd == nil {
+			continue
+		}
+		if value == "" {
+			// only the gateway sets the user , a value sent by the client is dropped
+			if name == injectedUserField {
+				msg.Clear(fd)
+			}
+			continue
+		}
+		if err := setField(msg, name, []string{value}); err != nil {
+			return nil, fmt.Errorf("field %s: %w", name, err)
+		}
+	}
+
+	return msg, nil
+}
+
+// isBoundField reports if a query param targets a field already bound by the body or a path variable
+func isBoundField(fieldPath, body string, pathParams map[string]string) bool {
+	if body != "" && (fieldPath == body || strings.HasPrefix(fieldPath, body+".")) {
+		return true
+	}
+	_, ok := pathParams[fieldPath]
+	return ok
+}
+
+// setField sets a (nested) field path like "post.id" from its string values.
+// Repeated fields take all the values, singular ones the last value.
+func setField(msg protoreflect.Message, fieldPath string, values []string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	names := strings.Split(fieldPath, ".")
+	for _, name := range names[:len(names)-1] {
+		fd := findField(msg.Descriptor(), name)
+		if fd == nil {
+			return errUnknownField
+		}
+		if fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+			return fmt.Errorf("%s is not a message field", name)
+		}
+		msg = msg.Mutable(fd).Message()
+	}
+
+	fd := findField(msg.Descriptor(), names[len(names)-1])
+	if fd == nil {
+		return errUnknownField
+	}
+	if fd.IsMap() {
+		return fmt.Errorf("map fields can`t be set from params")
+	}
+	if fd.IsList() {
+		list := msg.Mutable(fd).List()
+		for _, value := range values {
+			v, err := parseFieldValue(fd, value)
+			if err != nil {
+				return err
+			}
+			list.Append(v)
+		}
+		return nil
+	}
+	v, err := parseFieldValue(fd, values[len(values)-1])
+	if err != nil {
+		return err
+	}
+	msg.Set(fd, v)
+	return nil
+}
+
+// findField finds a field by its proto name or its json name
+func findField(desc protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	if fd := desc.Fields().ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	return desc.Fields().ByJSONName(name)
+}
+
+// parseFieldValue converts a path/query string to the field type
+func parseFieldValue(fd protoreflect.FieldDescriptor, value string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(value), nil
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(value)
+		return protoreflect.ValueOfBool(b), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, err := strconv.ParseInt(value, 10, 32)
+		return protoreflect.ValueOfInt32(int32(n)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, err := strconv.ParseInt(value, 10, 64)
+		return protoreflect.ValueOfInt64(n), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, err := strconv.ParseUint(value, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(n)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, err := strconv.ParseUint(value, 10, 64)
+		return protoreflect.ValueOfUint64(n), err
+	case protoreflect.FloatKind:
+		f, err := strconv.ParseFloat(value, 32)
+		return protoreflect.ValueOfFloat32(float32(f)), err
+	case protoreflect.DoubleKind:
+		f, err := strconv.ParseFloat(value, 64)
+		return protoreflect.ValueOfFloat64(f), err
+	case protoreflect.BytesKind:
+		b, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			b, err = base64.URLEncoding.DecodeString(value)
+		}
+		return protoreflect.ValueOfBytes(b), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(value)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("invalid enum value %q", value)
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+	case protoreflect.MessageKind:
+		// well known types (Timestamp, Duration, wrappers..) have a string JSON form
+		m := dynamicpb.NewMessage(fd.Message())
+		if err := protojson.Unmarshal([]byte(strconv.Quote(value)), m); err != nil {
+			return protoreflect.Value{}, fmt.Errorf("invalid value %q for %s", value, fd.Message().FullName())
+		}
+		return protoreflect.ValueOfMessage(m), nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported field kind %s", fd.Kind())
+}
+
+// marshalResponse marshals the whole response or only its response_body field
+func marshalResponse(respMsg *dynamicpb.Message, responseBody string) ([]byte, error) {
+	marshaler := protojson.MarshalOptions{UseProtoNames: true}
+	if responseBody == "" {
+		return marshaler.Marshal(respMsg)
+	}
+
+	fd := findField(respMsg.Descriptor(), responseBody)
+	if fd == nil {
+		return nil, fmt.Errorf("response_body field %s: %w", responseBody, errUnknownField)
+	}
+	if fd.Kind() == protoreflect.MessageKind && !fd.IsList() && !fd.IsMap() {
+		return marshaler.Marshal(respMsg.Get(fd).Message().Interface())
+	}
+
+	// scalars & repeated fields: marshal the response and take the field out
+	marshaler.EmitUnpopulated = true
+	data, err := marshaler.Marshal(respMsg)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields[string(fd.Name())], nil
+}
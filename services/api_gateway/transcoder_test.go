@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// testInvoker has the test.Posts/GetPost method (see testRequest)
+func testInvoker(t *testing.T) (*GRPCInvoker, *models.RouteConfig) {
+	t.Helper()
+	msg := testRequest(t)
+	g := NewGRPCInvoker(nil)
+	g.serviceDescriptors["test.Posts"] = &ServiceDescriptor{
+		serviceName: "test.Posts",
+		methods: map[string]*MethodDescriptor{"GetPost": {
+			methodName:       "GetPost",
+			inputDescriptor:  msg,
+			outputDescriptor: msg,
+			fullMethodName:   "/test.Posts/GetPost",
+		}},
+	}
+	return g, &models.RouteConfig{Method: "POST", Path: "/posts", Body: "*", GRPCService: "test.Posts", GRPCMethod: "GetPost"}
+}
+
+func TestBuildRequestInvalidBody(t *testing.T) {
+	g, route := testInvoker(t)
+	for _, body := range []string{`{bad json`, `{"PostId": 1}`} {
+		_, err := g.BuildRequest(route, []byte(body), nil, nil, nil)
+		if err == nil {
+			t.Fatalf("%s: no error", body)
+		}
+		w := httptest.NewRecorder()
+		writeStatus(w, statusFromRequestError(err))
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "INVALID_BODY") {
+			t.Errorf("%s: %d %s , want 400 INVALID_BODY", body, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestInvokeBackendStatus(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(grpc.UnknownServiceHandler(func(any, grpc.ServerStream) error {
+		return status.Error(codes.NotFound, "post 1 not found")
+	}))
+	go server.Serve(lis)
+	defer server.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	g, route := testInvoker(t)
+	req, err := g.BuildRequest(route, []byte(`{"PostId": "1"}`), nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.Invoke(context.Background(), conn, route, req)
+	w := httptest.NewRecorder()
+	writeGRPCError(w, err)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d , want 404", w.Code)
+	}
+	var body struct{ Message string }
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Message != "post 1 not found" {
+		t.Errorf("body = %s , want the backend message only", w.Body.String())
+	}
+}
@@ -4,13 +4,17 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/tracing"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/redis/go-redis/v9"
@@ -25,11 +29,6 @@ func LoadConfig() (models.ServerConfig, error) {
 	}
 	return config, nil
 }
-func InitLogger() {
-
-	log.SetOutput(os.Stdout)
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-}
 
 func loadLuaScripts(config *models.AppConfig) error {
 	script, err := os.ReadFile(config.Redis.AddScriptPath)
@@ -44,12 +43,15 @@ func loadLuaScripts(config *models.AppConfig) error {
 		return err
 	}
 	config.Redis.CheckScript = string(script)
-	script, err = os.ReadFile(config.RateLimiting.ScriptPath)
-	if err != nil {
-		log.Println("Error in Loading ", config.RateLimiting.ScriptPath)
-		return err
+	config.RateLimiting.RateLimitingScripts = make(map[string]string, len(config.RateLimiting.ScriptPaths))
+	for algorithm, path := range config.RateLimiting.ScriptPaths {
+		script, err = os.ReadFile(path)
+		if err != nil {
+			log.Println("Error in Loading ", path)
+			return err
+		}
+		config.RateLimiting.RateLimitingScripts[algorithm] = string(script)
 	}
-	config.RateLimiting.RateLimitingScript = string(script)
 
 	log.Println("---> All Lua Scripts Loaded successfully")
 	return nil
@@ -59,8 +61,7 @@ type PublicKeyResponse struct {
 	PublicKey string `json:"publicKey"`
 }
 
-// TODO: This is very naive way
-// Change it to more dynamic one
+// GetPublicKey fetches the legacy PEM key , used by the KeyManager when there is no JWKS url
 func GetPublicKey(addr string) ([]byte, error) {
 
 	var err error
@@ -75,7 +76,7 @@ func GetPublicKey(addr string) ([]byte, error) {
 	if resp.StatusCode != http.StatusOK {
 		// log.Println(resp.Status)
 		// log.Println(resp.StatusCode)
-		return nil, err
+		return nil, fmt.Errorf("public key status %s", resp.Status)
 	}
 
 	// log.Println(resp.StatusCode)
@@ -110,71 +111,250 @@ func LoadAppConfig(filename string) (*models.AppConfig, error) {
 	if publicKeyAddr := os.Getenv("PUBLIC_KEY_ADDR"); publicKeyAddr != "" {
 		config.Server.PublickeyAddr = publicKeyAddr
 	}
+	if jwksURL := os.Getenv("JWKS_URL"); jwksURL != "" {
+		config.Server.JWKSURL = jwksURL
+	}
+	if trustedProxies := os.Getenv("TRUSTED_PROXIES"); trustedProxies != "" {
+		config.Server.TrustedProxies = strings.Split(trustedProxies, ",")
+	}
+	for i, cidr := range config.Server.TrustedProxies {
+		prefix, err := parseTrustedProxy(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("server.trusted_proxies: %w", err)
+		}
+		config.Server.TrustedProxies[i] = prefix.String()
+	}
+	if clientIPHeader := os.Getenv("CLIENT_IP_HEADER"); clientIPHeader != "" {
+		config.Server.ClientIPHeader = clientIPHeader
+	}
+	if config.Server.ClientIPHeader == "" {
+		config.Server.ClientIPHeader = ClientIPHeaderXForwardedFor
+	}
+	clientIPHeader, err := parseClientIPHeader(config.Server.ClientIPHeader)
+	if err != nil {
+		return nil, fmt.Errorf("server.client_ip_header: %w", err)
+	}
+	config.Server.ClientIPHeader = clientIPHeader
 	if clusterAddr := os.Getenv("CLUSTER_ADDR"); clusterAddr != "" {
 		// log.Println(clusterAddr)
 		clusterAddr := strings.Split(clusterAddr, ",")
 		config.RateLimiting.Addr = clusterAddr
 	}
 
+	if replicas := os.Getenv("GATEWAY_REPLICAS"); replicas != "" {
+		n, err := strconv.Atoi(replicas)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GATEWAY_REPLICAS: %w", err)
+		}
+		config.RateLimiting.GatewayReplicas = n
+	}
+
 	if redisAddr := os.Getenv("REDIS_ADDR"); redisAddr != "" {
 		// log.Println(redisAddr)
 		config.Redis.RedisAddr = redisAddr
 	}
 
-	// if registeryAddr := os.Getenv("REGISTERY_ADDR"); registeryAddr != "" {
-	// 	config.ServiceRegistery.ServiceRegisteryPath = registeryAddr
-	// }
+	if secureCookies := os.Getenv("SECURE_COOKIES"); secureCookies != "" {
+		secure, err := strconv.ParseBool(secureCookies)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SECURE_COOKIES: %w", err)
+		}
+		config.Auth.SecureCookies = secure
+	}
+
+	if config.Auth.CSRFCookie == "" {
+		config.Auth.CSRFCookie = "csrfToken"
+	}
+	if config.Auth.CSRFHeader == "" {
+		config.Auth.CSRFHeader = "X-CSRF-Token"
+	}
+	if len(config.Auth.RefreshCookiePaths) == 0 {
+		config.Auth.RefreshCookiePaths = []string{"/api/v1/refresh", "/api/v1/logout"}
+	}
+
+	if len(config.CORS.AllowedMethods) == 0 {
+		config.CORS.AllowedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+	}
+	if len(config.CORS.AllowedHeaders) == 0 {
+		config.CORS.AllowedHeaders = []string{"Content-Type", "Authorization", "RefreshToken", config.Auth.CSRFHeader, requestIDHeader}
+	}
+	if len(config.CORS.ExposedHeaders) == 0 {
+		config.CORS.ExposedHeaders = []string{"X-Ratelimit-Limit", "X-Ratelimit-Remaining", "X-Ratelimit-Retry-After", "Retry-After", config.Auth.CSRFHeader, requestIDHeader, "ETag"}
+	}
+	if config.CORS.AllowCredentials == nil {
+		// the cookie auth needs them
+		allow := true
+		config.CORS.AllowCredentials = &allow
+	}
+
+	if config.Identity.Forward == nil {
+		config.Identity.Forward = []string{ForwardUserID, ForwardClientIP, ForwardUserAgent}
+	}
+	if err := checkIdentityForward(config.Identity.Forward); err != nil {
+		return nil, err
+	}
+
+	registery := &config.ServiceRegistery
+	if registeryType := os.Getenv("REGISTERY_TYPE"); registeryType != "" {
+		registery.Type = registeryType
+	}
+	if registeryAddr := os.Getenv("REGISTERY_ADDR"); registeryAddr != "" {
+		registery.ServiceRegisteryPath = registeryAddr
+	}
+	if registeryPrefix := os.Getenv("REGISTERY_PREFIX"); registeryPrefix != "" {
+		registery.ServiceRegisteryPrefix = registeryPrefix
+	}
+	if registery.Type == "" {
+		registery.Type = RegistryStatic
+	}
+	if registery.ServiceRegisteryPrefix == "" {
+		registery.ServiceRegisteryPrefix = "/services/"
+	}
+	// the services register under <prefix><service>/<id>
+	if !strings.HasSuffix(registery.ServiceRegisteryPrefix, "/") {
+		registery.ServiceRegisteryPrefix += "/"
+	}
+	if registery.RefreshInterval <= 0 {
+		registery.RefreshInterval = 10 * time.Second
+	}
+
+	for name, backend := range config.K8sServices {
+		if backend == nil {
+			return nil, fmt.Errorf("k8s_services.%s has no address", name)
+		}
+		// ex: POST_SERVICE_ADDR , the k8s manifests point the backends to their headless services
+		if addr := os.Getenv(strings.ToUpper(name) + "_ADDR"); addr != "" {
+			backend.Addr = addr
+		}
+		if backend.Registry == "" {
+			backend.Registry = registery.Type
+		}
+		switch backend.Registry {
+		case RegistryStatic, RegistryDNSSRV:
+			if backend.Addr == "" {
+				return nil, fmt.Errorf("k8s_services.%s has no address", name)
+			}
+		case RegistryEtcd:
+			if registery.ServiceRegisteryPath == "" {
+				return nil, fmt.Errorf("k8s_services.%s: the etcd registry needs service_registery_path", name)
+			}
+		default:
+			return nil, fmt.Errorf("k8s_services.%s: unknown registry %q", name, backend.Registry)
+		}
+		switch backend.Balancer {
+		case "":
+			backend.Balancer = "round_robin"
+		case "round_robin", "least_request":
+		default:
+			return nil, fmt.Errorf("k8s_services.%s: unknown balancer %q", name, backend.Balancer)
+		}
+		breaker := &backend.Breaker
+		if breaker.Window <= 0 {
+			breaker.Window = 10 * time.Second
+		}
+		if breaker.MinRequests <= 0 {
+			breaker.MinRequests = 20
+		}
+		if breaker.ErrorRate <= 0 {
+			breaker.ErrorRate = 0.5
+		}
+		if breaker.SlowCallRate <= 0 {
+			breaker.SlowCallRate = 0.5
+		}
+		if breaker.OpenTimeout <= 0 {
+			breaker.OpenTimeout = 10 * time.Second
+		}
+	}
+
+	if config.Calls.Timeout <= 0 {
+		config.Calls.Timeout = 10 * time.Second
+	}
+	retry := &config.Calls.Retry
+	if retry.MaxAttempts <= 0 {
+		retry.MaxAttempts = 3
+	}
+	if retry.InitialBackoff <= 0 {
+		retry.InitialBackoff = 50 * time.Millisecond
+	}
+	if retry.MaxBackoff <= 0 {
+		retry.MaxBackoff = time.Second
+	}
+	if retry.BudgetRatio <= 0 {
+		retry.BudgetRatio = 0.2
+	}
+	if retry.BudgetMinRetries <= 0 {
+		retry.BudgetMinRetries = 10
+	}
+
+	if exporter := os.Getenv("TRACING_EXPORTER"); exporter != "" {
+		config.Tracing.Exporter = exporter
+	}
+	if config.Tracing.SampleRatio == nil {
+		ratio := 1.0
+		config.Tracing.SampleRatio = &ratio
+	}
+	if ratio := *config.Tracing.SampleRatio; ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("tracing.sample_ratio must be between 0 and 1 , got %v", ratio)
+	}
+	if config.Tracing.ServiceName == "" {
+		config.Tracing.ServiceName = "api_gateway"
+	}
+	if config.Tracing.Exporter == tracing.ExporterFile && config.Tracing.File == "" {
+		config.Tracing.File = "traces.json"
+	}
 
 	return &config, nil
 }
 
 // This func will validate token & make sure that it is not revoked
 // by check redis instance
-func ValidateToken(token string, pubKey []byte, r *redis.Client, luaScript string) (string, error) {
-	if len(pubKey) == 0 {
-		log.Println("Empty public key")
-		return "", errors.New("empty PubKey")
-	}
+func ValidateToken(token string, keys *KeyManager, r *redis.Client, luaScript string) (jwt.MapClaims, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
 
 	tokenKey := "revoked:token:" + token
 	result, err := r.Eval(ctx, luaScript, []string{tokenKey}).Result()
 	if err != nil {
-		log.Printf("Error checking token denylist: %v", err)
+		slog.Error("error checking token denylist", "error", err)
+		redisErrors.WithLabelValues(redisTokenDenylist).Inc()
 		// Fail-open again
 	} else if res, ok := result.(int64); ok && res == 1 {
-		log.Printf("Token Revoked: %v", token)
-		return "", errors.New("invalid")
-	}
-	rsaPubKey, err := jwt.ParseRSAPublicKeyFromPEM(pubKey)
-	if err != nil {
-		log.Printf("Failed to parse RSA public key: %v", err)
-		return "", errors.New("invalid public key")
+		slog.Info("revoked token used")
+		tokenFailures.WithLabelValues("revoked").Inc()
+		return nil, errors.New("invalid")
 	}
 	parser := jwt.NewParser(
 		jwt.WithValidMethods([]string{"RS256"}),
 		jwt.WithAudience("api_gateway"),
 		jwt.WithIssuer("users_service"),
 	)
-	claims := jwt.RegisteredClaims{}
-	parse, err := parser.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
-		return rsaPubKey, nil
-	})
+	// all the claims , some of them can be forwarded to the backends
+	claims := jwt.MapClaims{}
+	// the key is selected by the token kid
+	parse, err := parser.ParseWithClaims(token, claims, keys.Keyfunc)
 	if err != nil {
+		if errors.Is(err, errUnknownKey) {
+			slog.Info("token signed with an unknown key")
+			tokenFailures.WithLabelValues("unknown_key").Inc()
+			return nil, errors.New("invalid")
+		}
 		if errors.Is(err, jwt.ErrTokenExpired) {
-			log.Printf("Token expired: %v", token)
-			return "", errors.New("invalid")
+			slog.Debug("token expired")
+			tokenFailures.WithLabelValues("expired").Inc()
+			return nil, errors.New("invalid")
 		}
-		return "", err
+		tokenFailures.WithLabelValues(tokenFailureReason(err)).Inc()
+		return nil, err
 	}
 	if !parse.Valid {
-		return "", errors.New("invalid")
+		tokenFailures.WithLabelValues("invalid").Inc()
+		return nil, errors.New("invalid")
 	}
-	if claims.Subject == "" {
-		return "", errors.New("invalid")
+	if subject, _ := claims.GetSubject(); subject == "" {
+		tokenFailures.WithLabelValues("missing_subject").Inc()
+		return nil, errors.New("invalid")
 	}
-	return claims.Subject, nil
+	return claims, nil
 }
 
 // type RedisPool struct {
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/api_gateway/models"
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Cross origin handshakes are rejected (gorilla default CheckOrigin)
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// wsConn serializes the writes to the WebSocket (only one writer is allowed at a time)
+type wsConn struct {
+	mu           sync.Mutex
+	conn         *websocket.Conn
+	writeTimeout time.Duration
+}
+
+func (c *wsConn) write(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.writeTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	return c.conn.WriteMessage(messageType, data)
+}
+
+// writeError sends a google.rpc.Status frame , the connection stays open
+func (c *wsConn) writeError(st *status.Status) error {
+	return c.write(websocket.TextMessage, append(append([]byte(`{"error":`), statusJSON(st)...), '}'))
+}
+
+func (c *wsConn) close(code int, reason string) {
+	c.write(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+	c.conn.Close()
+}
+
+// messageLimiter is the per connection message rate limit
+type messageLimiter struct {
+	bucket localBucket
+	limit  float64
+	rate   float64
+}
+
+func newMessageLimiter(cfg models.WebSocketConfig) *messageLimiter {
+	if cfg.MessageRate <= 0 {
+		return nil
+	}
+	burst := float64(cfg.MessageBurst)
+	if burst < 1 {
+		burst = cfg.MessageRate
+	}
+	return &messageLimiter{
+		bucket: localBucket{tokens: burst, lastRefill: time.Now()},
+		limit:  burst,
+		rate:   cfg.MessageRate,
+	}
+}
+
+func (ml *messageLimiter) Allow() bool {
+	if ml == nil {
+		return true
+	}
+	return ml.bucket.take(ml.limit, ml.rate, time.Now())
+}
+
+// serveWebSocket bridges a WebSocket to a client/bidi-streaming gRPC call.
+// Every JSON text frame is a request message , every response is sent back as a JSON text frame.
+// Auth and the connection rate limits are already checked by GenericHandler.
+func (h *Handler) serveWebSocket(w http.ResponseWriter, r *http.Request, st *gatewayState, conn *grpc.ClientConn, route *models.RouteConfig, pathParams map[string]string, injected map[string]string) {
+	cfg := st.config.WebSocket
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already wrote the HTTP error
+		slog.InfoContext(r.Context(), "websocket upgrade failed", "route", route.Path, "error", err)
+		return
+	}
+	defer ws.Close()
+	c := &wsConn{conn: ws, writeTimeout: cfg.WriteTimeout}
+	if cfg.MaxMessageBytes > 0 {
+		ws.SetReadLimit(cfg.MaxMessageBytes)
+	}
+
+	// the client disconnects are seen by ReadMessage (not the request context) after the upgrade
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	stream, err := st.grpcInvoker.NewClientStream(ctx, conn, route)
+	if err != nil {
+		slog.WarnContext(ctx, "websocket stream failed", "route", route.Path, "error", err)
+		c.writeError(sanitizeStatus(status.Convert(err)))
+		c.close(websocket.CloseInternalServerErr, "")
+		return
+	}
+
+	// keepalive: the peer must answer the pings
+	if cfg.PingInterval > 0 {
+		ws.SetReadDeadline(time.Now().Add(2 * cfg.PingInterval))
+		ws.SetPongHandler(func(string) error {
+			return ws.SetReadDeadline(time.Now().Add(2 * cfg.PingInterval))
+		})
+		go func() {
+			ticker := time.NewTicker(cfg.PingInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if err := c.write(websocket.PingMessage, nil); err != nil {
+						cancel()
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	// client -> backend
+	go func() {
+		if !readFrames(ctx, c, st.grpcInvoker, stream, route, pathParams, injected, newMessageLimiter(cfg)) {
+			// the client is gone , end the backend stream too
+			cancel()
+			return
+		}
+		// no more requests , the backend can still send responses
+		stream.CloseSend()
+		// keep reading so the control frames (pong , close) are handled
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	// backend -> client
+	for {
+		data, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			c.close(websocket.CloseNormalClosure, "")
+			return
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.WarnContext(ctx, "websocket stream error", "route", route.Path, "error", err)
+				grpcStatus, ok := status.FromError(err)
+				if !ok {
+					grpcStatus = status.New(codes.Internal, "")
+				}
+				c.writeError(sanitizeStatus(grpcStatus))
+			}
+			c.close(websocket.CloseInternalServerErr, "")
+			return
+		}
+		if err := c.write(websocket.TextMessage, data); err != nil {
+			slog.DebugContext(ctx, "websocket write failed", "route", route.Path, "error", err)
+			return
+		}
+	}
+}
+
+// readFrames sends the client frames to the backend.
+// An empty frame half-closes the stream (returns true) , ex: to get the response of a client-streaming call.
+// It returns false when the client closes the connection.
+func readFrames(ctx context.Context, c *wsConn, invoker *GRPCInvoker, stream *ClientStream, route *models.RouteConfig, pathParams map[string]string, injected map[string]string, limiter *messageLimiter) bool {
+	for {
+		messageType, frame, err := c.conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) && ctx.Err() == nil {
+				slog.DebugContext(ctx, "websocket read failed", "route", route.Path, "error", err)
+			}
+			return false
+		}
+		if messageType == websocket.TextMessage && len(frame) == 0 {
+			return true
+		}
+		if messageType != websocket.TextMessage {
+			c.writeError(grpcError(codes.InvalidArgument, "INVALID_FRAME", "Only JSON text frames are supported"))
+			continue
+		}
+		if !limiter.Allow() {
+			c.writeError(grpcError(codes.ResourceExhausted, "MESSAGE_RATE_EXCEEDED", "Message rate exceeded", retryInfo(1)))
+			continue
+		}
+
+		// the frame is the whole request , path params and the gateway fields are set like unary calls
+		reqMsg, err := invoker.BuildRequest(route, frame, pathParams, url.Values{}, injected)
+		if err != nil {
+			c.writeError(statusFromRequestError(err))
+			continue
+		}
+		if err := stream.Send(reqMsg); err != nil {
+			// the backend ended the stream , Recv gets the status
+			return true
+		}
+	}
+}
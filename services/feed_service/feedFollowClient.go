@@ -3,11 +3,14 @@ package main
 import (
 	"context"
 	"log"
+	"log/slog"
 
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 
 	pb "github.com/alimx07/Distributed_Microservices_Backend/services/services_bindings_go"
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/logging"
 )
 
 type FollowClient struct {
@@ -16,7 +19,11 @@ type FollowClient struct {
 }
 
 func NewFollowClient(target string) (*FollowClient, error) {
-	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient(target,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+		grpc.WithUnaryInterceptor(logging.UnaryClientInterceptor),
+	)
 	if err != nil {
 		log.Println("Error in Connection to Follow Service: ", err.Error())
 		return nil, err
@@ -35,7 +42,7 @@ func (fc *FollowClient) GetFollowers(ctx context.Context, id string) ([]string,
 	}
 	res, err := fc.client.GetFollowers(ctx, req)
 	if err != nil {
-		log.Printf("Error in Fetching Follower IDs for user: %v , Err: %v", id, err.Error())
+		slog.ErrorContext(ctx, "error in fetching follower ids", "user_id", id, "error", err)
 		return nil, err
 	}
 	return res.FollowerID, nil
@@ -47,7 +54,7 @@ func (fc *FollowClient) IsCeleb(ctx context.Context, id string) (bool, error) {
 	}
 	res, err := fc.client.IsCeleb(ctx, req)
 	if err != nil {
-		log.Printf("Error in Fetching Follower IDs for user: %v , Err: %v", id, err.Error())
+		slog.ErrorContext(ctx, "error in fetching follower ids", "user_id", id, "error", err)
 		return false, err
 	}
 	return res.IsCeleb, nil
@@ -59,7 +66,7 @@ func (fc *FollowClient) GetCeleb(ctx context.Context, id string) ([]string, erro
 	}
 	res, err := fc.client.GetFollowers(ctx, req)
 	if err != nil {
-		log.Printf("Error in Fetching Follower IDs for user: %v , Err: %v", id, err.Error())
+		slog.ErrorContext(ctx, "error in fetching follower ids", "user_id", id, "error", err)
 		return nil, err
 	}
 	return res.FollowerID, nil
@@ -3,9 +3,12 @@ package main
 import (
 	"context"
 	"log"
+	"log/slog"
 
 	"github.com/alimx07/Distributed_Microservices_Backend/services/feed_service/models"
 	pb "github.com/alimx07/Distributed_Microservices_Backend/services/services_bindings_go"
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/logging"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -16,7 +19,11 @@ type PostClient struct {
 }
 
 func NewPostClient(target string) (*PostClient, error) {
-	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient(target,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+		grpc.WithUnaryInterceptor(logging.UnaryClientInterceptor),
+	)
 	if err != nil {
 		log.Println("Error in Connection to Post Service: ", err.Error())
 		return nil, err
@@ -38,7 +45,7 @@ func (pc *PostClient) GetPosts(ctx context.Context, items []models.FeedItem) ([]
 	}
 	res, err := pc.client.GetPosts(ctx, req)
 	if err != nil {
-		log.Println("Error in Fetching posts: ", err.Error())
+		slog.ErrorContext(ctx, "error in fetching posts", "error", err)
 		return nil, nil, err
 	}
 	posts := make([]*pb.FeedPost, 0, len(res.Post))
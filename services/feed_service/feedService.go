@@ -3,15 +3,13 @@ package main
 import (
 	"context"
 	"encoding/base64"
-
-	// "fmt"
+	"fmt"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"slices"
 	"strconv"
-
-	// "strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -19,11 +17,17 @@ import (
 	cachedrepo "github.com/alimx07/Distributed_Microservices_Backend/services/feed_service/cachedRepo"
 	"github.com/alimx07/Distributed_Microservices_Backend/services/feed_service/models"
 	pb "github.com/alimx07/Distributed_Microservices_Backend/services/services_bindings_go"
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/identity"
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/logging"
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/registry"
 
-	// "github.com/google/uuid"
-	// etcd "go.etcd.io/etcd/client/v3"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 )
 
@@ -38,10 +42,11 @@ type FeedService struct {
 	followClient *FollowClient
 	userClient   *UserClient
 	grpcServer   *grpc.Server
+	healthServer *health.Server
 	httpServer   *http.Server
 	wg           *sync.WaitGroup
 	serviceOFF   atomic.Bool
-	// etcdClient   *etcd.Client
+	registration *registry.Registration
 }
 
 func NewFeedService(config models.ServerConfig, Kconfig models.KafkaConfig, cacheConfig models.RedisConfig) (*FeedService, error) {
@@ -102,31 +107,42 @@ func (fs *FeedService) Start() error {
 		log.Printf("Error in Starting listener for FeedService on %v\n", net.JoinHostPort(fs.config.ServerHost, fs.config.ServerPort))
 		return err
 	}
-	grpcServer := grpc.NewServer()
+	// server spans continue the trace of the gateway (traceparent metadata)
+	grpcServer := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		// request id of the gateway in the logs
+		// the user comes from the gateway metadata
+		grpc.ChainUnaryInterceptor(logging.UnaryServerInterceptor, identity.ServerInterceptor),
+		// the clients reconnect (and re-resolve the pods) , so the new pods get traffic
+		grpc.KeepaliveParams(keepalive.ServerParameters{MaxConnectionAge: 5 * time.Minute, MaxConnectionAgeGrace: 30 * time.Second}),
+	)
 	pb.RegisterFeedServiceServer(grpcServer, fs)
+	// grpc.health.v1 , the gateway stops calling this instance once it is NOT_SERVING
+	fs.healthServer = health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, fs.healthServer)
+	// the gateway can load our descriptors over server reflection
+	reflection.Register(grpcServer)
 	fs.grpcServer = grpcServer
-	// etcdClient, err := etcd.New(etcd.Config{Endpoints: strings.Split(fs.config.EtcdEndpoints, ","), DialTimeout: 5 * time.Second})
-	// if err != nil {
-	// 	log.Printf("Error in Register instance of feedService: %v", err)
-	// 	return err
-	// }
-	// fs.etcdClient = etcdClient
-	// lease, err := etcdClient.Grant(fs.ctx, 5)
-	// if err != nil {
-	// 	log.Printf("Error in Creating Lease to instance of feedService: %v", err)
-	// 	return err
-	// }
-	// uuid := uuid.New()
-	// etcdClient.Put(context.Background(), fmt.Sprintf("/services/feed_service/%v", uuid), net.JoinHostPort(fs.config.HostName, fs.config.ServerPort), etcd.WithLease(lease.ID))
-	// etcdClient.KeepAlive(fs.ctx, lease.ID)
+	// without k8s the gateway finds this instance in etcd
+	if fs.config.EtcdEndpoints != "" {
+		registration, err := registry.Register(fs.config.EtcdEndpoints, fs.config.RegistryPrefix, "feed_service", net.JoinHostPort(fs.config.HostName, fs.config.ServerPort))
+		if err != nil {
+			return fmt.Errorf("failed to register the instance: %w", err)
+		}
+		fs.registration = registration
+	}
 	return grpcServer.Serve(conn)
 }
 
 // We will get the feed from hybird fanout and
 // Merge them and return top N posts (sorted by timestamp) and cursor for next fetch
 func (fs *FeedService) GetFeed(ctx context.Context, req *pb.GetFeedRequest) (*pb.GetFeedResponse, error) {
+	user, err := identity.UserID(ctx)
+	if err != nil {
+		return nil, err
+	}
 	c := models.Cursor{
-		UserId:   req.GetUserId(),
+		UserId:   user,
 		Cursor:   req.GetCursor(),
 		PageSize: req.GetPageSize(),
 	}
@@ -136,10 +152,9 @@ func (fs *FeedService) GetFeed(ctx context.Context, req *pb.GetFeedRequest) (*pb
 	// items := make([]models.FeedItem, 0, c.PageSize*2)
 
 	// First Get precomputed UserFeed Posts
-	log.Println(fs == nil, fs.cache == nil)
 	items, curr_score, err := fs.cache.Get(c)
 	if err != nil {
-		log.Println("Error in Getting UserFeed Cache Items:", err.Error())
+		slog.WarnContext(ctx, "error in getting user feed cache items", "error", err)
 	}
 
 	timeoutCtx, c1 := context.WithTimeout(ctx, 2*time.Second)
@@ -191,7 +206,7 @@ func (fs *FeedService) GetFeed(ctx context.Context, req *pb.GetFeedRequest) (*pb
 	defer c2()
 	posts, user_ids, err := fs.postClient.GetPosts(postsCtx, items)
 	if err != nil {
-		log.Println("feed generation failed. We can`t get items from DB", err.Error())
+		slog.ErrorContext(ctx, "feed generation failed , we can`t get items from DB", "error", err)
 		return nil, status.Error(codes.Internal, "Failed to getFeed Due to internal Issues")
 	}
 
@@ -200,7 +215,7 @@ func (fs *FeedService) GetFeed(ctx context.Context, req *pb.GetFeedRequest) (*pb
 	defer c3()
 	users, err := fs.userClient.GetUsersData(userCtx, user_ids)
 	if err != nil {
-		log.Println("Failed To get Users Metadata. Error in Users Service connection or internals", err.Error())
+		slog.WarnContext(ctx, "failed to get users metadata", "error", err)
 	} else {
 		for i := range posts {
 			if v, ok := users[posts[i].UserId]; ok {
@@ -247,6 +262,12 @@ func (fs *FeedService) close() {
 
 	// mark service as down
 	fs.serviceOFF.Store(true)
+	if fs.healthServer != nil {
+		fs.healthServer.Shutdown()
+	}
+	if fs.registration != nil {
+		fs.registration.Deregister()
+	}
 
 	// wait until new state reflected in api_gateway
 	time.Sleep(5 * time.Second)
@@ -266,7 +287,6 @@ func (fs *FeedService) close() {
 	}
 
 	fs.cancel()
-	// fs.etcdClient.Close()
 
 	// wait to any pending requests
 	done := make(chan struct{})
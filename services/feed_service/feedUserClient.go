@@ -3,8 +3,11 @@ package main
 import (
 	"context"
 	"log"
+	"log/slog"
 
 	pb "github.com/alimx07/Distributed_Microservices_Backend/services/services_bindings_go"
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/logging"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -15,7 +18,11 @@ type UserClient struct {
 }
 
 func NewUserClient(target string) (*UserClient, error) {
-	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient(target,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+		grpc.WithUnaryInterceptor(logging.UnaryClientInterceptor),
+	)
 	if err != nil {
 		log.Println("Error in Connection to User Service: ", err.Error())
 		return nil, err
@@ -31,7 +38,7 @@ func (uc *UserClient) GetUsersData(ctx context.Context, ids []string) (map[strin
 	req := &pb.GetUsersDataRequest{UserId: ids}
 	res, err := uc.client.GetUsersData(ctx, req)
 	if err != nil {
-		log.Println("Failed to get userData from Users Service:", err.Error())
+		slog.ErrorContext(ctx, "failed to get users data from users service", "error", err)
 		return nil, err
 	}
 	users := make(map[string]string)
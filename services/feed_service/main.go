@@ -6,11 +6,16 @@ import (
 	"net/http"
 	"os/signal"
 	"syscall"
+	"time"
+
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/logging"
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/tracing"
 )
 
 func main() {
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	logging.Init("feed_service")
 	appConfig, err := LoadConfig()
 	if err != nil {
 		log.Fatal("Error in Loading Service Config: ", err.Error())
@@ -24,6 +29,15 @@ func main() {
 		log.Fatal("Error in Loading Redis Config: ", err.Error())
 	}
 
+	tracingConfig, err := LoadTracingConfig()
+	if err != nil {
+		log.Fatal("Error in Loading Tracing Config: ", err.Error())
+	}
+	shutdownTracing, err := tracing.Init(ctx, tracingConfig)
+	if err != nil {
+		log.Fatal("Error in Starting Tracing: ", err.Error())
+	}
+
 	service, err := NewFeedService(appConfig, KafkaConfig, cacheConfig)
 	if err != nil {
 		log.Fatal("Error in Starting Feed Service: ", err.Error())
@@ -52,4 +66,10 @@ func main() {
 	stop()
 
 	service.close()
+
+	tctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(tctx); err != nil {
+		log.Println("Error in flushing spans: ", err.Error())
+	}
 }
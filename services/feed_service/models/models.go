@@ -20,14 +20,16 @@ type ServerConfig struct {
 	PostService    string
 	UserService    string
 	FollowService  string
-	// EtcdEndpoints  string
-	HostName string
+	EtcdEndpoints  string // registers the instance for the gateway , empty on k8s
+	RegistryPrefix string // service_registery_prefix of the gateway (default /services/)
+	HostName       string
 }
 
 type FeedItem struct {
-	PostId     string `json:"post_id"`
-	UserId     string `json:"user_id"`
-	Created_at int64  `json:"created_at"`
+	PostId      string `json:"post_id"`
+	UserId      string `json:"user_id"`
+	Created_at  int64  `json:"created_at"`
+	TraceParent string `json:"trace_parent,omitempty"` // W3C traceparent of the CreatePost call (from the CDC event)
 }
 
 type Cursor struct {
@@ -0,0 +1,7 @@
+package main
+
+import (
+	"go.opentelemetry.io/otel"
+)
+
+var tracer = otel.Tracer("feed_service")
@@ -4,9 +4,11 @@ import (
 	"encoding/base64"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/alimx07/Distributed_Microservices_Backend/services/feed_service/models"
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/tracing"
 )
 
 func ValidateCursorData(c string, pageSize int32) (string, int32) {
@@ -43,8 +45,9 @@ func LoadConfig() (models.ServerConfig, error) {
 		PostService:    os.Getenv("POST_SERVICE"),
 		UserService:    os.Getenv("USER_SERVICE"),
 		FollowService:  os.Getenv("FOLLOW_SERVICE"),
-		// EtcdEndpoints:  os.Getenv("ETCD_ENDPOINTS"),
-		HostName: os.Getenv("HOSTNAME"),
+		EtcdEndpoints:  os.Getenv("ETCD_ENDPOINTS"),
+		RegistryPrefix: os.Getenv("REGISTERY_PREFIX"),
+		HostName:       os.Getenv("HOSTNAME"),
 	}
 	return config, nil
 }
@@ -57,6 +60,26 @@ func LoadRedisConfig() (models.RedisConfig, error) {
 	return config, nil
 }
 
+func LoadTracingConfig() (tracing.Config, error) {
+	config := tracing.Config{
+		ServiceName: "feed_service",
+		Exporter:    os.Getenv("TRACING_EXPORTER"),
+		File:        os.Getenv("TRACING_FILE"),
+		SampleRatio: 1,
+	}
+	if config.File == "" {
+		config.File = "traces.json"
+	}
+	if ratio := os.Getenv("TRACING_SAMPLE_RATIO"); ratio != "" {
+		r, err := strconv.ParseFloat(ratio, 64)
+		if err != nil {
+			return config, err
+		}
+		config.SampleRatio = r
+	}
+	return config, nil
+}
+
 func LoadkakfaConfig() (models.KafkaConfig, error) {
 	config := models.KafkaConfig{
 		BootStrapServers: os.Getenv("BOOTSTRAP_SERVERS"),
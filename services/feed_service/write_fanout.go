@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"log/slog"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -11,6 +12,11 @@ import (
 	cachedrepo "github.com/alimx07/Distributed_Microservices_Backend/services/feed_service/cachedRepo"
 	"github.com/alimx07/Distributed_Microservices_Backend/services/feed_service/models"
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // FollowClientInterface defines the interface for follow client operations
@@ -82,7 +88,7 @@ func (fw *FanoutWriter) WriteFanout() {
 					defer fw.wg.Done()
 					err := fw.ProcessMessage(e)
 					if err != nil {
-						log.Println("Error Processing Message", err.Error())
+						slog.Error("error processing message", "topic", messageTopic(e), "offset", e.TopicPartition.Offset.String(), "error", err)
 					} else {
 						fw.c.Commit()
 					}
@@ -102,20 +108,25 @@ func (fw *FanoutWriter) ProcessMessage(msg *kafka.Message) error {
 	if err != nil {
 		return err
 	}
+	spanCtx, span := startFanoutSpan(fw.ctx, msg, item)
+	defer span.End()
 	// log.Println("POSTID --> ", item.PostId)
-	ctx, c1 := context.WithTimeout(fw.ctx, 5*time.Second)
+	ctx, c1 := context.WithTimeout(spanCtx, 5*time.Second)
 	defer c1()
 	celeb, _ := fw.followClient.IsCeleb(ctx, item.UserId)
 	if celeb {
 		fw.cache.Set(item)
 		return nil
 	}
-	ctx2, c2 := context.WithTimeout(fw.ctx, 5*time.Second)
+	ctx2, c2 := context.WithTimeout(spanCtx, 5*time.Second)
 	defer c2()
 	followers, err := fw.followClient.GetFollowers(ctx2, item.UserId)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "get followers failed")
 		return err
 	}
+	span.SetAttributes(attribute.Int("feed.followers", len(followers)))
 	i := int32(0)
 	var wg sync.WaitGroup
 	for int(atomic.LoadInt32(&i)) < len(followers) {
@@ -137,6 +148,66 @@ func (fw *FanoutWriter) ProcessMessage(msg *kafka.Message) error {
 	return nil
 }
 
+// kafkaHeaders adapts the message headers to the otel propagators
+type kafkaHeaders struct {
+	headers *[]kafka.Header
+}
+
+func (kh kafkaHeaders) Get(key string) string {
+	for _, h := range *kh.headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (kh kafkaHeaders) Set(key, value string) {
+	*kh.headers = append(*kh.headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (kh kafkaHeaders) Keys() []string {
+	keys := make([]string, 0, len(*kh.headers))
+	for _, h := range *kh.headers {
+		keys = append(keys, h.Key)
+	}
+	return keys
+}
+
+// startFanoutSpan starts the span of a fanout. It is a new trace (the fanout runs long after
+// the request) linked to the trace that created the post. The trace context comes from the
+// message headers , or the trace_parent column of the post sent by the CDC connector.
+func startFanoutSpan(ctx context.Context, msg *kafka.Message, item models.FeedItem) (context.Context, trace.Span) {
+	propagator := otel.GetTextMapPropagator()
+	producer := propagator.Extract(context.Background(), kafkaHeaders{&msg.Headers})
+	if !trace.SpanContextFromContext(producer).IsValid() && item.TraceParent != "" {
+		producer = propagator.Extract(context.Background(), propagation.MapCarrier{"traceparent": item.TraceParent})
+	}
+
+	topic := messageTopic(msg)
+	opts := []trace.SpanStartOption{
+		trace.WithNewRoot(),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "kafka"),
+			attribute.String("messaging.destination.name", topic),
+			attribute.String("feed.post_id", item.PostId),
+		),
+	}
+	if sc := trace.SpanContextFromContext(producer); sc.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: sc}))
+	}
+	return tracer.Start(ctx, "fanout "+topic, opts...)
+}
+
+// messageTopic is the topic of a message , "" if the client did not set it
+func messageTopic(msg *kafka.Message) string {
+	if msg.TopicPartition.Topic == nil {
+		return ""
+	}
+	return *msg.TopicPartition.Topic
+}
+
 func (fw *FanoutWriter) close() error {
 	// wait until all goroutines end
 	fw.wg.Wait()
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"log/slog"
 	"strconv"
 	"strings"
 	"time"
@@ -95,7 +96,7 @@ func (rs *redisRepo) GetPosts(ctx context.Context, ids []string) ([]models.Post,
 	}
 
 	postVals := mgetvalues.Val()
-	log.Println("PostVals len: ", len(postVals))
+	slog.Debug("cached posts", "count", len(postVals))
 
 	idx := make(map[string]int)
 	for i, val := range postVals {
@@ -6,25 +6,41 @@ import (
 	"net/http"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/alimx07/Distributed_Microservices_Backend/services/post_service/cachedRepo"
 	"github.com/alimx07/Distributed_Microservices_Backend/services/post_service/postRepo"
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/logging"
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/tracing"
 )
 
 func main() {
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	logging.Init("post_service")
 	config, err := LoadConfig()
 	if err != nil {
 		log.Fatal("Failed to load config file: ", err.Error())
 	}
 
+	tracingConfig, err := LoadTracingConfig()
+	if err != nil {
+		log.Fatal("Failed to load tracing config: ", err.Error())
+	}
+	shutdownTracing, err := tracing.Init(ctx, tracingConfig)
+	if err != nil {
+		log.Fatal("Failed to start tracing: ", err.Error())
+	}
+
 	primaryDB, replicaDB, err := InitDBConnections(config)
 	if err != nil {
 		log.Fatal("Failed to initialize database connections: ", err.Error())
 	}
 
 	postRepo := postRepo.NewPostgresRepo(primaryDB, replicaDB)
+	if err := postRepo.Migrate(ctx); err != nil {
+		log.Fatal("Failed to migrate the database: ", err.Error())
+	}
 	cachedRepo, err := cachedRepo.NewRedisRepo(postRepo, config.CacheAddrs, config.CachePassword)
 	if err != nil {
 		log.Println("Error in Loading Redis Cluster: ", err.Error())
@@ -54,4 +70,10 @@ func main() {
 	stop()
 
 	postService.close()
+
+	tctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(tctx); err != nil {
+		log.Println("Error in flushing spans: ", err.Error())
+	}
 }
@@ -23,14 +23,16 @@ type Config struct {
 	ServerPort     string
 	ServerHttpPort string
 
-	// EtcdEndpoints string
-	HostName string
+	EtcdEndpoints  string // registers the instance for the gateway , empty on k8s
+	RegistryPrefix string // service_registery_prefix of the gateway (default /services/)
+	HostName       string
 }
 
 type Post struct {
 	CachedPost
-	Likes_count    int64 `json:"likes_count"`
-	Comments_count int64 `json:"comments_count"`
+	Likes_count    int64  `json:"likes_count"`
+	Comments_count int64  `json:"comments_count"`
+	Trace_parent   string `json:"-"` // written with the post for the CDC consumers
 }
 
 type CachedPost struct {
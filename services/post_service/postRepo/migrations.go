@@ -0,0 +1,33 @@
+package postRepo
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+)
+
+// migrations update the databases created before the primary_init scripts changed.
+// Every statement is idempotent , they run on every start in the name order.
+//
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+func (ps *PostgresRepo) Migrate(ctx context.Context) error {
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		script, err := migrations.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if _, err := ps.primaryDB.ExecContext(ctx, string(script)); err != nil {
+			return fmt.Errorf("migration %s: %w", name, err)
+		}
+		log.Println("Applied migration: ", name)
+	}
+	return nil
+}
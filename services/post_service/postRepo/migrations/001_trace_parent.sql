@@ -0,0 +1,11 @@
+-- posts created before the tracing keep a NULL trace_parent
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS trace_parent TEXT;
+
+-- the CDC events carry the trace_parent to the feed fanout
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM pg_publication WHERE pubname = 'my_pub') THEN
+		ALTER PUBLICATION my_pub SET TABLE posts(user_id , post_id , created_at , trace_parent);
+	END IF;
+END
+$$;
@@ -31,9 +31,9 @@ func (ps *PostgresRepo) CreatePost(ctx context.Context, post models.Post) (strin
 	entropy := ulid.Monotonic(rand.New(rand.NewSource(time.Now().UnixNano())), 0)
 	postID := ulid.MustNew(ulid.Timestamp(time.Now()), entropy).String()
 	_, err := ps.primaryDB.ExecContext(ctx,
-		`INSERT INTO posts (post_id , user_id, content) 
-        VALUES ($1, $2, $3)`,
-		postID, post.CachedPost.User_id, post.CachedPost.Content)
+		`INSERT INTO posts (post_id , user_id, content, trace_parent) 
+        VALUES ($1, $2, $3, NULLIF($4, ''))`,
+		postID, post.CachedPost.User_id, post.CachedPost.Content, post.Trace_parent)
 	if err != nil {
 		log.Println("Error creating post: ", err.Error())
 		return "", err
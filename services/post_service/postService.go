@@ -2,12 +2,11 @@ package main
 
 import (
 	"context"
-	// "fmt"
+	"fmt"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
-
-	// "strings"
 	"sync/atomic"
 	"time"
 
@@ -15,11 +14,17 @@ import (
 	"github.com/alimx07/Distributed_Microservices_Backend/services/post_service/models"
 	"github.com/alimx07/Distributed_Microservices_Backend/services/post_service/postRepo"
 	pb "github.com/alimx07/Distributed_Microservices_Backend/services/services_bindings_go"
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/identity"
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/logging"
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/registry"
 
-	// "github.com/google/uuid"
-	// etcd "go.etcd.io/etcd/client/v3"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 )
 
@@ -32,8 +37,9 @@ type postService struct {
 	config        models.Config
 	httpServer    *http.Server
 	grpcServer    *grpc.Server
+	healthServer  *health.Server
 	serviceOFF    atomic.Bool
-	// etcdClient    *etcd.Client
+	registration  *registry.Registration
 }
 
 func NewPostService(presistance postRepo.PersistenceDB, cache cachedRepo.CachedRepo, config models.Config) *postService {
@@ -53,42 +59,52 @@ func (ps *postService) start() error {
 	if err != nil {
 		return err
 	}
-	grpcserver := grpc.NewServer()
+	// server spans continue the trace of the caller (traceparent metadata)
+	grpcserver := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		// request id of the caller in the logs
+		// the user comes from the gateway metadata
+		grpc.ChainUnaryInterceptor(logging.UnaryServerInterceptor, identity.ServerInterceptor),
+		// the clients reconnect (and re-resolve the pods) , so the new pods get traffic
+		grpc.KeepaliveParams(keepalive.ServerParameters{MaxConnectionAge: 5 * time.Minute, MaxConnectionAgeGrace: 30 * time.Second}),
+	)
 	ps.grpcServer = grpcserver
 	pb.RegisterPostSeriveServer(grpcserver, ps)
-
-	// etcdClient, err := etcd.New(etcd.Config{Endpoints: strings.Split(ps.config.EtcdEndpoints, ","), DialTimeout: 5 * time.Second})
-	// if err != nil {
-	// 	log.Printf("Error in Register instance of PostService: %v", err)
-	// 	return err
-	// }
-	// ps.etcdClient = etcdClient
-	// lease, err := etcdClient.Grant(ps.ctx, 5)
-	// if err != nil {
-	// 	log.Printf("Error in Creating Lease to instance of PostService: %v", err)
-	// 	return err
-	// }
-	// uuid := uuid.New()
-	// etcdClient.Put(context.Background(), fmt.Sprintf("/services/post_service/%v", uuid), net.JoinHostPort(ps.config.HostName, ps.config.ServerPort), etcd.WithLease(lease.ID))
-	// etcdClient.KeepAlive(ps.ctx, lease.ID)
+	// grpc.health.v1 , the gateway stops calling this instance once it is NOT_SERVING
+	ps.healthServer = health.NewServer()
+	healthpb.RegisterHealthServer(grpcserver, ps.healthServer)
+	// the gateway can load our descriptors over server reflection
+	reflection.Register(grpcserver)
+
+	// without k8s the gateway finds this instance in etcd
+	if ps.config.EtcdEndpoints != "" {
+		registration, err := registry.Register(ps.config.EtcdEndpoints, ps.config.RegistryPrefix, "post_service", net.JoinHostPort(ps.config.HostName, ps.config.ServerPort))
+		if err != nil {
+			return fmt.Errorf("failed to register the instance: %w", err)
+		}
+		ps.registration = registration
+	}
 	return grpcserver.Serve(listener)
 }
 
 func (ps *postService) CreatePost(ctx context.Context, req *pb.Post) (*pb.Response, error) {
-	log.Println("DATA: ", req.GetContent(), "  ", req.GetUserId())
-	post := models.CachedPost{User_id: req.GetUserId(),
+	user, err := identity.UserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	post := models.CachedPost{User_id: user,
 		Content: req.GetContent()}
 
-	log.Println(post.User_id, post.Content)
-	id, err := ps.presistanceDB.CreatePost(ctx, models.Post{CachedPost: post})
+	slog.DebugContext(ctx, "creating post", "user_id", post.User_id, "content_length", len(post.Content))
+	id, err := ps.presistanceDB.CreatePost(ctx, models.Post{CachedPost: post, Trace_parent: traceParent(ctx)})
 	if err != nil {
-		log.Printf("Failed to create post for user{%v}: {%v}\n", post.User_id, err.Error())
+		slog.ErrorContext(ctx, "failed to create post", "user_id", post.User_id, "error", err)
 		return nil, status.Error(codes.Internal, "Post Can`t be Created Due to internal Issues")
 	}
 	post.Id = id
 	err = ps.cache.CachePost(ctx, post)
 	if err != nil {
-		log.Printf("Failed to cache post{%v} for user{%v}\n : {%v}", post.Id, post.User_id, err.Error())
+		slog.WarnContext(ctx, "failed to cache post", "post_id", post.Id, "user_id", post.User_id, "error", err)
 	}
 	return &pb.Response{
 		Message: "Post Created Successfully",
@@ -96,14 +112,18 @@ func (ps *postService) CreatePost(ctx context.Context, req *pb.Post) (*pb.Respon
 }
 
 func (ps *postService) CreateComment(ctx context.Context, req *pb.Comment) (*pb.Response, error) {
+	user, err := identity.UserID(ctx)
+	if err != nil {
+		return nil, err
+	}
 	comment := models.Comment{
-		User_id: req.GetUserId(),
+		User_id: user,
 		Post_id: req.GetPostId(),
 		Content: req.GetComment(),
 	}
 	id, err := ps.presistanceDB.CreateComment(ctx, comment)
 	if err != nil {
-		log.Printf("Failed to create comment on post{%v} by user{%v}: %v", comment.Post_id, comment.User_id, err.Error())
+		slog.ErrorContext(ctx, "failed to create comment", "post_id", comment.Post_id, "user_id", comment.User_id, "error", err)
 		return nil, status.Error(codes.Internal, "Failed to create comment Due to internal Issues")
 	}
 	ps.cache.UpdateCommentsCounter(ctx, id, 1)
@@ -113,13 +133,17 @@ func (ps *postService) CreateComment(ctx context.Context, req *pb.Comment) (*pb.
 }
 
 func (ps *postService) CreateLike(ctx context.Context, req *pb.Like) (*pb.Response, error) {
+	user, err := identity.UserID(ctx)
+	if err != nil {
+		return nil, err
+	}
 	like := models.Like{
-		User_id: req.UserId,
+		User_id: user,
 		Post_id: req.PostId,
 	}
-	err := ps.presistanceDB.CreateLike(ctx, like)
+	err = ps.presistanceDB.CreateLike(ctx, like)
 	if err != nil {
-		log.Printf("Failed to create Like on post{%v} by user{%v} : %v", like.Post_id, like.User_id, err.Error())
+		slog.ErrorContext(ctx, "failed to create like", "post_id", like.Post_id, "user_id", like.User_id, "error", err)
 		return nil, status.Error(codes.Internal, "Failed to create like Due to internal Issues")
 	}
 	ps.cache.UpdateLikesCounter(ctx, like.Post_id, 1)
@@ -132,7 +156,7 @@ func (ps *postService) DeletePost(ctx context.Context, req *pb.DeletePostRequest
 	id := req.GetPostId()
 	err := ps.presistanceDB.DeletePost(ctx, id)
 	if err != nil {
-		log.Printf("Failed to delete post{%v}: %v\n", id, err.Error())
+		slog.ErrorContext(ctx, "failed to delete post", "post_id", id, "error", err)
 		return nil, status.Error(codes.Internal, "Failed to Delete Post Due to Internal Issues")
 	}
 
@@ -141,7 +165,7 @@ func (ps *postService) DeletePost(ctx context.Context, req *pb.DeletePostRequest
 		// in case of cache failing , then there will be inconsistency as users can still see the post until expiration
 		// even it is deleted. a background proccess can be spin up to take cache failed operations and
 		// retry them again. for simplecity just log the error now
-		log.Printf("Failed to Delete post {%v} from the cache: {%v}\n", id, err.Error())
+		slog.WarnContext(ctx, "failed to delete post from the cache", "post_id", id, "error", err)
 	}
 
 	return &pb.Response{
@@ -154,7 +178,7 @@ func (ps *postService) DeleteComment(ctx context.Context, req *pb.DeleteCommentR
 
 	err := ps.presistanceDB.DeleteComment(ctx, id)
 	if err != nil {
-		log.Printf("Failed to delete Comment{%v}: %v\n", id, err.Error())
+		slog.ErrorContext(ctx, "failed to delete comment", "comment_id", id, "error", err)
 		return nil, status.Error(codes.Internal, "Failed to Delete Comment Due to Internal Issues")
 	}
 	ps.cache.UpdateCommentsCounter(ctx, id, -1)
@@ -165,11 +189,14 @@ func (ps *postService) DeleteComment(ctx context.Context, req *pb.DeleteCommentR
 
 func (ps *postService) DeleteLike(ctx context.Context, req *pb.DeleteLikeRequest) (*pb.Response, error) {
 	id := req.GetPostId()
-	user_id := req.GetUserId()
+	user_id, err := identity.UserID(ctx)
+	if err != nil {
+		return nil, err
+	}
 
-	err := ps.presistanceDB.DeleteLike(ctx, id, user_id)
+	err = ps.presistanceDB.DeleteLike(ctx, id, user_id)
 	if err != nil {
-		log.Printf("Failed to delete Like{%v} for user{%v}: %v\n", id, user_id, err.Error())
+		slog.ErrorContext(ctx, "failed to delete like", "post_id", id, "user_id", user_id, "error", err)
 		return nil, status.Error(codes.Internal, "Failed to Delete Like Due to Internal Issues")
 	}
 	ps.cache.UpdateLikesCounter(ctx, id, -1)
@@ -184,10 +211,10 @@ func (ps *postService) GetPosts(ctx context.Context, req *pb.GetPostRequest) (*p
 	var err error
 	posts, err = ps.cache.GetPosts(ctx, ids)
 	if err != nil {
-		log.Println("Error in Get Posts from Cache: ", err.Error())
+		slog.WarnContext(ctx, "error in get posts from cache", "error", err)
 		posts, err = ps.presistanceDB.GetPosts(ctx, ids)
 		if err != nil {
-			log.Println("Error in GetPosts From DB, ", err.Error())
+			slog.ErrorContext(ctx, "error in get posts from DB", "error", err)
 			return nil, err
 		}
 	}
@@ -234,9 +261,14 @@ func (ps *postService) StartHealthServer() error {
 func (ps *postService) close() {
 
 	ps.cancel()
-	// ps.etcdClient.Close()
 	// mark service as OFF
 	ps.serviceOFF.Store(true)
+	if ps.healthServer != nil {
+		ps.healthServer.Shutdown()
+	}
+	if ps.registration != nil {
+		ps.registration.Deregister()
+	}
 
 	// wait until state reflected in api_gateway
 	time.Sleep(5 * time.Second)
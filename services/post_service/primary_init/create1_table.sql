@@ -4,7 +4,8 @@ CREATE TABLE IF NOT EXISTS posts(
 	content TEXT NOT NULL,
 	created_at TIMESTAMP DEFAULT NOW(),
 	likes_count BIGINT DEFAULT 0,
-	comments_count BIGINT DEFAULT 0
+	comments_count BIGINT DEFAULT 0,
+	trace_parent TEXT -- W3C traceparent of the request , read by the feed fanout from the CDC events
 );
 
 CREATE TABLE IF NOT EXISTS comments (
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// traceParent is the W3C traceparent of the current span ("" if not traced).
+// It is stored with the post so the feed fanout (CDC event) can link to the request that created it.
+func traceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier["traceparent"]
+}
@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/alimx07/Distributed_Microservices_Backend/services/post_service/models"
+	"github.com/alimx07/Distributed_Microservices_Backend/services/shared_go/tracing"
 	_ "github.com/lib/pq"
 )
 
@@ -32,8 +34,29 @@ func LoadConfig() (models.Config, error) {
 		ServerHttpPort: os.Getenv("SERVER_HTTP_PORT"),
 		CachePassword:  os.Getenv("CACHE_PASSWORD"),
 		CacheAddrs:     strings.Split(os.Getenv("CLUSTER_ADDR"), ","),
-		// EtcdEndpoints:  os.Getenv("ETCD_ENDPOINTS"),
-		HostName: os.Getenv("HOSTNAME"),
+		EtcdEndpoints:  os.Getenv("ETCD_ENDPOINTS"),
+		RegistryPrefix: os.Getenv("REGISTERY_PREFIX"),
+		HostName:       os.Getenv("HOSTNAME"),
+	}
+	return config, nil
+}
+
+func LoadTracingConfig() (tracing.Config, error) {
+	config := tracing.Config{
+		ServiceName: "post_service",
+		Exporter:    os.Getenv("TRACING_EXPORTER"),
+		File:        os.Getenv("TRACING_FILE"),
+		SampleRatio: 1,
+	}
+	if config.File == "" {
+		config.File = "traces.json"
+	}
+	if ratio := os.Getenv("TRACING_SAMPLE_RATIO"); ratio != "" {
+		r, err := strconv.ParseFloat(ratio, 64)
+		if err != nil {
+			return config, err
+		}
+		config.SampleRatio = r
 	}
 	return config, nil
 }
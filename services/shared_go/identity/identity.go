@@ -0,0 +1,36 @@
+// Package identity reads the identity the gateway forwards as gRPC metadata.
+package identity
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UserIDMetadata is the user of the verified token , always sent by the gateway (identity.forward: user_id)
+const UserIDMetadata = "x-user-id"
+
+type userIDKey struct{}
+
+// ServerInterceptor keeps the user of the caller in the context ,
+// the handlers read it with UserID instead of a request field the client could set
+func ServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(UserIDMetadata); len(ids) > 0 && ids[0] != "" {
+			ctx = context.WithValue(ctx, userIDKey{}, ids[0])
+		}
+	}
+	return handler(ctx, req)
+}
+
+// UserID is the authenticated user of the call , Unauthenticated without it
+func UserID(ctx context.Context) (string, error) {
+	id, _ := ctx.Value(userIDKey{}).(string)
+	if id == "" {
+		return "", status.Error(codes.Unauthenticated, "no authenticated user")
+	}
+	return id, nil
+}
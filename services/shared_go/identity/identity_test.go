@@ -0,0 +1,29 @@
+package identity
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestServerInterceptor(t *testing.T) {
+	handler := func(ctx context.Context, req any) (any, error) {
+		return UserID(ctx)
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/feed.FeedService/GetFeed"}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(UserIDMetadata, "42"))
+	user, err := ServerInterceptor(ctx, nil, info, handler)
+	if err != nil || user != "42" {
+		t.Errorf("user = %v , err = %v", user, err)
+	}
+
+	_, err = ServerInterceptor(context.Background(), nil, info, handler)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("err = %v , want Unauthenticated", err)
+	}
+}
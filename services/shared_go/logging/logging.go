@@ -0,0 +1,163 @@
+// Package logging is the slog setup of the Go services: JSON records with the request id
+// and the trace id of the context , redacted the same way in every service.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDMetadata carries the request id of the gateway to the backends , and between the backends
+const RequestIDMetadata = "x-request-id"
+
+type requestIDKey struct{}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// ContextAttrs returns more attributes of the context , added to every record (ex: the client ip of the gateway)
+type ContextAttrs func(ctx context.Context) []slog.Attr
+
+// Init logs JSON with slog (LOG_LEVEL: debug , info , warn , error).
+// The log package calls go through the same handler at info level.
+func Init(service string, extra ...ContextAttrs) {
+	level := slog.LevelInfo
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			slog.Warn("invalid LOG_LEVEL", "value", v)
+		}
+	}
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		AddSource:   true,
+		Level:       level,
+		ReplaceAttr: Redact,
+	})
+	slog.SetDefault(slog.New(NewContextHandler(handler, extra...)).With("service", service))
+}
+
+// contextHandler adds the request id and the trace id of the context to every record
+type contextHandler struct {
+	slog.Handler
+	extra []ContextAttrs
+}
+
+func NewContextHandler(handler slog.Handler, extra ...ContextAttrs) slog.Handler {
+	return contextHandler{Handler: handler, extra: extra}
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	for _, attrs := range h.extra {
+		r.AddAttrs(attrs(ctx)...)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs), h.extra}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name), h.extra}
+}
+
+const redacted = "[REDACTED]"
+
+var (
+	// sensitive attribute keys (lower case substrings)
+	sensitiveKeys = []string{"token", "password", "secret", "authorization", "cookie", "email"}
+
+	// sensitive values inside any string (ex: the messages of the log package calls , the errors)
+	jwtPattern    = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	bearerPattern = regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9._~+/-]+=*`)
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	// ex: the DB connection strings
+	dsnPassword = regexp.MustCompile(`password=\S+`)
+)
+
+// Redact hides the tokens , passwords and emails before they are written (slog ReplaceAttr).
+// Errors and Stringers are written as their text , so it is scrubbed like a string.
+func Redact(groups []string, a slog.Attr) slog.Attr {
+	if a.Key != slog.MessageKey && a.Key != slog.SourceKey {
+		key := strings.ToLower(a.Key)
+		for _, s := range sensitiveKeys {
+			if strings.Contains(key, s) {
+				return slog.String(a.Key, redacted)
+			}
+		}
+	}
+	value := a.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, redactString(value.String()))
+	case slog.KindAny:
+		switch v := value.Any().(type) {
+		case error:
+			return slog.String(a.Key, redactString(v.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, redactString(v.String()))
+		}
+	}
+	return a
+}
+
+// redactString hides the tokens , passwords and emails inside s
+func redactString(s string) string {
+	s = jwtPattern.ReplaceAllString(s, redacted)
+	s = bearerPattern.ReplaceAllString(s, "Bearer "+redacted)
+	s = dsnPassword.ReplaceAllString(s, "password="+redacted)
+	return emailPattern.ReplaceAllString(s, redacted)
+}
+
+// UnaryServerInterceptor keeps the request id of the caller in the context and logs the call
+func UnaryServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDMetadata); len(ids) > 0 {
+			ctx = WithRequestID(ctx, ids[0])
+		}
+	}
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	slog.InfoContext(ctx, "rpc",
+		"method", info.FullMethod,
+		"code", status.Code(err).String(),
+		"duration", time.Since(start),
+	)
+	return resp, err
+}
+
+// UnaryClientInterceptor forwards the request id of the context on every call
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if id := RequestID(ctx); id != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, RequestIDMetadata, id)
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if id := RequestID(ctx); id != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, RequestIDMetadata, id)
+	}
+	return streamer(ctx, desc, cc, method, opts...)
+}
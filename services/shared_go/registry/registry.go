@@ -0,0 +1,112 @@
+// Package registry registers the Go services in etcd , the gateway watches their prefix
+// (service_registery.type: etcd).
+package registry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	etcd "go.etcd.io/etcd/client/v3"
+)
+
+// TTL is how long a dead instance stays registered (seconds)
+const TTL = 10
+
+// DefaultPrefix is the service_registery_prefix of the gateway if REGISTERY_PREFIX is not set
+const DefaultPrefix = "/services/"
+
+// Registration keeps this instance under <prefix><service>/<id> while it runs
+type Registration struct {
+	client *etcd.Client
+	key    string
+	addr   string
+	lease  etcd.LeaseID
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Register puts addr (host:port) with a lease kept alive in the background ,
+// it is registered again if the lease is lost (ex: etcd restarted).
+// prefix must be the service_registery_prefix of the gateway ("" is DefaultPrefix).
+func Register(endpoints, prefix, service, addr string) (*Registration, error) {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	client, err := etcd.New(etcd.Config{Endpoints: strings.Split(endpoints, ","), DialTimeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Registration{
+		client: client,
+		key:    fmt.Sprintf("%s%s/%s", prefix, service, uuid.New()),
+		addr:   addr,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	keepAlive, err := r.put(ctx)
+	if err != nil {
+		cancel()
+		client.Close()
+		return nil, err
+	}
+	slog.Info("instance registered", "key", r.key, "addr", addr)
+	go r.keep(ctx, keepAlive)
+	return r, nil
+}
+
+func (r *Registration) put(ctx context.Context) (<-chan *etcd.LeaseKeepAliveResponse, error) {
+	lease, err := r.client.Grant(ctx, TTL)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.client.Put(ctx, r.key, r.addr, etcd.WithLease(lease.ID)); err != nil {
+		return nil, err
+	}
+	r.lease = lease.ID
+	return r.client.KeepAlive(ctx, lease.ID)
+}
+
+func (r *Registration) keep(ctx context.Context, keepAlive <-chan *etcd.LeaseKeepAliveResponse) {
+	defer close(r.done)
+	for {
+		// drained , the channel is closed when the lease is lost
+		for range keepAlive {
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Warn("registry lease lost , registering again", "key", r.key)
+		for {
+			var err error
+			if keepAlive, err = r.put(ctx); err == nil {
+				break
+			}
+			slog.Error("failed to register the instance", "key", r.key, "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}
+}
+
+// Deregister removes the instance at once (the gateway stops calling it) instead of after the TTL
+func (r *Registration) Deregister() {
+	r.cancel()
+	<-r.done
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := r.client.Revoke(ctx, r.lease); err != nil {
+		slog.Warn("failed to revoke the registry lease", "key", r.key, "error", err)
+	}
+	r.client.Close()
+}
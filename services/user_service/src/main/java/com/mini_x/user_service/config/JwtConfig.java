@@ -5,15 +5,21 @@ import java.nio.file.Files;
 import java.nio.file.Paths;
 import java.security.KeyFactory;
 import java.security.PrivateKey;
+import java.security.MessageDigest;
 import java.security.PublicKey;
+import java.security.interfaces.RSAPublicKey;
 import java.security.spec.PKCS8EncodedKeySpec;
 import java.security.spec.X509EncodedKeySpec;
+import java.util.ArrayList;
 import java.util.Base64;
+import java.util.List;
 
+import org.springframework.beans.factory.annotation.Qualifier;
 import org.springframework.beans.factory.annotation.Value;
 import org.springframework.context.annotation.Bean;
 import org.springframework.context.annotation.Configuration;
 import org.springframework.core.io.Resource;
+import org.springframework.core.io.ResourceLoader;
 
 @Configuration
 public class JwtConfig {
@@ -24,6 +30,11 @@ public class JwtConfig {
     @Value("${jwt.public-key-path:classpath:keys/public.pem}")
     private Resource publicKeyResource;
 
+    // public keys that still verify tokens but don't sign anymore (the previous key) or not yet (the next key),
+    // served in the JWKS next to the signing key during a rotation
+    @Value("${jwt.verification-public-key-paths:}")
+    private String[] verificationKeyPaths;
+
     @Bean
     public PrivateKey jwtPrivateKey() throws Exception {
         String privateKeyPEM = new String(Files.readAllBytes(Paths.get(privateKeyResource.getURI())))
@@ -40,7 +51,22 @@ public class JwtConfig {
 
     @Bean
     public PublicKey jwtPublicKey() throws Exception {
-        String publicKeyPEM = new String(Files.readAllBytes(Paths.get(publicKeyResource.getURI())))
+        return readPublicKey(publicKeyResource);
+    }
+
+    @Bean
+    public List<PublicKey> jwtVerificationKeys(ResourceLoader resourceLoader) throws Exception {
+        List<PublicKey> keys = new ArrayList<>();
+        for (String path : verificationKeyPaths) {
+            if (!path.isBlank()) {
+                keys.add(readPublicKey(resourceLoader.getResource(path.trim())));
+            }
+        }
+        return keys;
+    }
+
+    private static PublicKey readPublicKey(Resource resource) throws Exception {
+        String publicKeyPEM = new String(Files.readAllBytes(Paths.get(resource.getURI())))
             .replace("-----BEGIN PUBLIC KEY-----", "")
             .replace("-----END PUBLIC KEY-----", "")
             .replaceAll("\\s+", "");
@@ -56,4 +82,25 @@ public class JwtConfig {
     public String publicKeyPem() throws IOException {
         return new String(Files.readAllBytes(Paths.get(publicKeyResource.getURI())));
     }
+
+    // kid of the signing key: RFC 7638 thumbprint, so it changes with the key
+    @Bean
+    public String jwtKeyId(@Qualifier("jwtPublicKey") PublicKey jwtPublicKey) throws Exception {
+        return keyId((RSAPublicKey) jwtPublicKey);
+    }
+
+    public static String keyId(RSAPublicKey rsaKey) throws Exception {
+        String canonical = "{\"e\":\"" + base64Url(rsaKey.getPublicExponent().toByteArray())
+            + "\",\"kty\":\"RSA\",\"n\":\"" + base64Url(rsaKey.getModulus().toByteArray()) + "\"}";
+        byte[] digest = MessageDigest.getInstance("SHA-256").digest(canonical.getBytes());
+        return Base64.getUrlEncoder().withoutPadding().encodeToString(digest);
+    }
+
+    // unsigned big-endian base64url (JWK format), BigInteger adds a leading 0 byte for the sign
+    public static String base64Url(byte[] bytes) {
+        if (bytes.length > 1 && bytes[0] == 0) {
+            bytes = java.util.Arrays.copyOfRange(bytes, 1, bytes.length);
+        }
+        return Base64.getUrlEncoder().withoutPadding().encodeToString(bytes);
+    }
 }
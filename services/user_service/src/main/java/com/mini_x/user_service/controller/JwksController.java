@@ -0,0 +1,56 @@
+package com.mini_x.user_service.controller;
+
+import java.security.PublicKey;
+import java.security.interfaces.RSAPublicKey;
+import java.util.ArrayList;
+import java.util.LinkedHashMap;
+import java.util.List;
+import java.util.Map;
+
+import org.slf4j.Logger;
+import org.slf4j.LoggerFactory;
+import org.springframework.beans.factory.annotation.Qualifier;
+import org.springframework.web.bind.annotation.GetMapping;
+import org.springframework.web.bind.annotation.RestController;
+
+import com.mini_x.user_service.config.JwtConfig;
+
+// Serves the JWT verification keys as a JWKS document.
+// Gateways select the key by the token `kid`, so keys can be rotated without restarting them:
+// the signing key is served with the keys of jwt.verification-public-key-paths
+// (the previous key until its tokens expire, or the next key before it signs).
+@RestController
+public class JwksController {
+
+    private static final Logger logger = LoggerFactory.getLogger(JwksController.class);
+
+    private final Map<String, Object> jwks;
+
+    public JwksController(@Qualifier("jwtPublicKey") PublicKey jwtPublicKey,
+            @Qualifier("jwtVerificationKeys") List<PublicKey> jwtVerificationKeys) throws Exception {
+        // by kid, the signing key first
+        Map<String, Map<String, String>> keys = new LinkedHashMap<>();
+        List<PublicKey> publicKeys = new ArrayList<>();
+        publicKeys.add(jwtPublicKey);
+        publicKeys.addAll(jwtVerificationKeys);
+        for (PublicKey publicKey : publicKeys) {
+            RSAPublicKey rsaKey = (RSAPublicKey) publicKey;
+            String kid = JwtConfig.keyId(rsaKey);
+            keys.putIfAbsent(kid, Map.of(
+                "kty", "RSA",
+                "use", "sig",
+                "alg", "RS256",
+                "kid", kid,
+                "n", JwtConfig.base64Url(rsaKey.getModulus().toByteArray()),
+                "e", JwtConfig.base64Url(rsaKey.getPublicExponent().toByteArray())));
+        }
+        this.jwks = Map.of("keys", List.copyOf(keys.values()));
+        logger.info("JwksController initialized with kids={}", keys.keySet());
+    }
+
+    @GetMapping("/.well-known/jwks.json")
+    public Map<String, Object> getJwks() {
+        logger.debug("Received request for JWKS");
+        return jwks;
+    }
+}
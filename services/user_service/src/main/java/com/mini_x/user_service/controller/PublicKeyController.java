@@ -5,6 +5,7 @@ import java.util.Map;
 
 import org.slf4j.Logger;
 import org.slf4j.LoggerFactory;
+import org.springframework.beans.factory.annotation.Qualifier;
 import org.springframework.web.bind.annotation.GetMapping;
 import org.springframework.web.bind.annotation.RestController;
 
@@ -15,7 +16,7 @@ public class PublicKeyController {
     
     private final String publicKeyPem;
 
-    public PublicKeyController(String publicKeyPem) {
+    public PublicKeyController(@Qualifier("publicKeyPem") String publicKeyPem) {
         this.publicKeyPem = publicKeyPem;
         logger.info("PublicKeyController initialized");
         if (publicKeyPem == null || publicKeyPem.isEmpty()) {
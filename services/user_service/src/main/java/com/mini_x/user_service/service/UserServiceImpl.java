@@ -15,6 +15,7 @@ import java.util.Optional;
 
 import org.slf4j.Logger;
 import org.slf4j.LoggerFactory;
+import org.springframework.beans.factory.annotation.Qualifier;
 import org.springframework.beans.factory.annotation.Value;
 import org.springframework.stereotype.Service;
 import org.springframework.transaction.annotation.Transactional;
@@ -42,6 +43,7 @@ public class UserServiceImpl implements UserService {
     private final UserCache userCache;
     private final TokenCacheService tokenCacheService;
     private final PrivateKey jwtPrivateKey;
+    private final String jwtKeyId;
     private final SecureRandom secureRandom;
 
     @Value("${jwt.expiration:604800}")
@@ -58,12 +60,14 @@ public class UserServiceImpl implements UserService {
             ReadRepo readRepo, 
             UserCache userCache,
             TokenCacheService tokenCacheService,
-            PrivateKey jwtPrivateKey) {
+            PrivateKey jwtPrivateKey,
+            @Qualifier("jwtKeyId") String jwtKeyId) {
         this.writeRepo = writeRepo;
         this.readRepo = readRepo;
         this.userCache = userCache;
         this.tokenCacheService = tokenCacheService;
         this.jwtPrivateKey = jwtPrivateKey;
+        this.jwtKeyId = jwtKeyId;
         this.secureRandom = new SecureRandom();
     }
 
@@ -150,6 +154,7 @@ public class UserServiceImpl implements UserService {
             }
             Long remainingTTL = tokenCacheService.getRefreshTokenTTL(refreshToken);
             String accessToken = Jwts.builder()
+                .header().keyId(jwtKeyId).and()
                 .subject(userId)
                 .issuer("users_service")
                 .audience().add("api_gateway").and()
@@ -243,6 +248,7 @@ public class UserServiceImpl implements UserService {
     private TokenPair generateTokenPair(String userId) {
         logger.debug("Generating token pair for userId={}", userId);
         String accessToken = Jwts.builder()
+            .header().keyId(jwtKeyId).and()
             .subject(userId)
             .issuer("users_service")
             .audience().add("api_gateway").and()